@@ -4,100 +4,1040 @@ import (
 	"context"
 	"github.com/Mrkuib/spx-back/internal/core"
 	"github.com/goplus/yap"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 type project struct {
 	yap.App
-	p *core.Project
+	p       *core.Project
+	conf    *core.Config
+	handler http.Handler
 }
 
-//line cmd/project_yap.gox:11
+//line cmd/project_yap.gox:19
 func (this *project) MainEntry() {
-//line cmd/project_yap.gox:11:1
-	todo := context.TODO()
-//line cmd/project_yap.gox:13:1
-	this.Get("/project/:id", func(ctx *yap.Context) {
-//line cmd/project_yap.gox:14:1
-		id := ctx.Param("id")
-//line cmd/project_yap.gox:15:1
-		res, _ := this.p.FileInfo(todo, id)
-//line cmd/project_yap.gox:16:1
-		ctx.Json__1(map[string]interface {
-		}{"code": 200, "msg": "OK", "data": map[string]string{"id": res.ID, "address": os.Getenv("QINIU_PATH") + res.Address}})
-	})
+//line cmd/project_yap.gox:19:1
+	standalone := this.p == nil
+//line cmd/project_yap.gox:20:1
+	if standalone {
+//line cmd/project_yap.gox:21:1
+		var err error
+//line cmd/project_yap.gox:22:1
+		this.conf, err = core.LoadConfig(os.Args[1:])
 //line cmd/project_yap.gox:23:1
-	this.Get("/asset/:id", func(ctx *yap.Context) {
+		if err != nil {
 //line cmd/project_yap.gox:24:1
-		ctx.ResponseWriter.Header().Set("Access-Control-Allow-Origin", "*")
-//line cmd/project_yap.gox:25:1
-		ctx.ResponseWriter.Header().Set("Content-Type", "application/json")
+			log.Fatalln(err)
+		}
 //line cmd/project_yap.gox:26:1
-		id := ctx.Param("id")
+		this.p, err = core.New(context.Background(), this.conf)
 //line cmd/project_yap.gox:27:1
-		asset, _ := this.p.Asset(todo, id)
+		if err != nil {
 //line cmd/project_yap.gox:28:1
-		ctx.Json__1(map[string]interface {
-		}{"code": 200, "msg": "ok", "data": map[string]*core.Asset{"asset": asset}})
-	})
-//line cmd/project_yap.gox:35:1
-	this.Get("/list/asset/:pageIndex/:pageSize/:assetType", func(ctx *yap.Context) {
-//line cmd/project_yap.gox:36:1
-		ctx.ResponseWriter.Header().Set("Access-Control-Allow-Origin", "*")
+			log.Fatalln(err)
+		}
+	}
 //line cmd/project_yap.gox:37:1
-		ctx.ResponseWriter.Header().Set("Content-Type", "application/json")
+	projectRoutes := yap.New()
 //line cmd/project_yap.gox:38:1
-		pageIndex := ctx.Param("pageIndex")
-//line cmd/project_yap.gox:39:1
-		pageSize := ctx.Param("pageSize")
+	this.Mux.Handle("/project/", projectRoutes)
 //line cmd/project_yap.gox:40:1
-		assetType := ctx.Param("assetType")
+	getProject := func(ctx *yap.Context) {
 //line cmd/project_yap.gox:41:1
-		result, _ := this.p.AssetList(todo, pageIndex, pageSize, assetType)
+		id := ctx.Param("id")
 //line cmd/project_yap.gox:42:1
-		ctx.Json__1(map[string]interface {
-		}{"code": 200, "msg": "ok", "data": result})
-	})
+		uid := core.UserID(ctx.Request)
+//line cmd/project_yap.gox:43:1
+		res, err := this.p.FileInfo(ctx.Context(), id, uid)
+//line cmd/project_yap.gox:44:1
+		if err != nil {
+//line cmd/project_yap.gox:45:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:46:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:47:1
+			return
+		}
 //line cmd/project_yap.gox:49:1
-	this.Post("/project/save", func(ctx *yap.Context) {
+		this.p.RecordView(ctx.Context(), res, uid, core.ClientIP(ctx.Request))
 //line cmd/project_yap.gox:50:1
-		id := ctx.FormValue("id")
+		res.Address = this.conf.QiniuPath + res.Address
 //line cmd/project_yap.gox:51:1
-		uid := ctx.FormValue("uid")
-//line cmd/project_yap.gox:52:1
-		name := ctx.FormValue("name")
+		ctx.Json__1(core.OK(res))
+	}
 //line cmd/project_yap.gox:53:1
-		file, header, _ := ctx.FormFile("file")
+	this.Get("/project/:id", getProject)
 //line cmd/project_yap.gox:54:1
-		codeFile := &core.CodeFile{ID: id, Name: name, AuthorId: uid}
+	this.Get("/api/v1/projects/:id", getProject)
+//line cmd/project_yap.gox:56:1
+	this.Get("/asset/:id", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:57:1
+		ctx.ResponseWriter.Header().Set("Content-Type", "application/json")
+//line cmd/project_yap.gox:58:1
+		id := ctx.Param("id")
 //line cmd/project_yap.gox:59:1
-		res, _ := this.p.SaveProject(todo, codeFile, file, header)
+		asset, err := this.p.Asset(ctx.Context(), id)
 //line cmd/project_yap.gox:60:1
-		ctx.Json__1(map[string]interface {
-		}{"code": 200, "msg": "ok", "data": map[string]string{"id": res.ID, "address": os.Getenv("QINIU_PATH") + res.Address}})
+		if err != nil {
+//line cmd/project_yap.gox:61:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:62:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:63:1
+			return
+		}
+//line cmd/project_yap.gox:65:1
+		this.p.RecordAssetView(ctx.Context(), asset, core.UserID(ctx.Request), core.ClientIP(ctx.Request))
+//line cmd/project_yap.gox:66:1
+		ctx.Json__1(core.OK(map[string]*core.AssetResponse{"asset": asset}))
 	})
-//line cmd/project_yap.gox:68:1
-	this.Post("/project/fmt", func(ctx *yap.Context) {
 //line cmd/project_yap.gox:69:1
-		ctx.ResponseWriter.Header().Set("Access-Control-Allow-Origin", "*")
+	this.Get("/list/asset/:pageIndex/:pageSize/:assetType", func(ctx *yap.Context) {
 //line cmd/project_yap.gox:70:1
 		ctx.ResponseWriter.Header().Set("Content-Type", "application/json")
 //line cmd/project_yap.gox:71:1
-		body := ctx.FormValue("body")
+		pageIndex := ctx.Param("pageIndex")
 //line cmd/project_yap.gox:72:1
-		imports := ctx.FormValue("import")
+		pageSize := ctx.Param("pageSize")
 //line cmd/project_yap.gox:73:1
-		res := this.p.CodeFmt(todo, body, imports)
+		assetType := ctx.Param("assetType")
 //line cmd/project_yap.gox:74:1
-		ctx.Json__1(map[string]interface {
-		}{"code": 200, "msg": "ok", "data": res})
+		result, err := this.p.AssetList(ctx.Context(), pageIndex, pageSize, assetType, ctx.Param("sort"))
+//line cmd/project_yap.gox:75:1
+		if err != nil {
+//line cmd/project_yap.gox:76:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:77:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:78:1
+			return
+		}
+//line cmd/project_yap.gox:80:1
+		ctx.Json__1(core.OK(result))
 	})
-//line cmd/project_yap.gox:82:1
-	conf := &core.Config{}
 //line cmd/project_yap.gox:83:1
-	this.p, _ = core.New(todo, conf)
+	saveProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:84:1
+		id := ctx.FormValue("id")
 //line cmd/project_yap.gox:85:1
-	this.Run__1(":8080")
+		uid := core.UserID(ctx.Request)
+//line cmd/project_yap.gox:86:1
+		name := ctx.FormValue("name")
+//line cmd/project_yap.gox:87:1
+		format := ctx.FormValue("format") == "1"
+//line cmd/project_yap.gox:88:1
+		file, header, err := ctx.FormFile("file")
+//line cmd/project_yap.gox:89:1
+		if err != nil {
+//line cmd/project_yap.gox:90:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:91:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:92:1
+			return
+		}
+//line cmd/project_yap.gox:94:1
+		codeFile := &core.CodeFile{ID: id, Name: name, AuthorId: uid, Visibility: ctx.FormValue("visibility")}
+//line cmd/project_yap.gox:100:1
+		res, err := this.p.SaveProject(ctx.Context(), codeFile, file, header, format)
+//line cmd/project_yap.gox:101:1
+		if err != nil {
+//line cmd/project_yap.gox:102:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:103:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:104:1
+			return
+		}
+//line cmd/project_yap.gox:106:1
+		res.Address = this.conf.QiniuPath + res.Address
+//line cmd/project_yap.gox:107:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:109:1
+	this.Post("/project/save", saveProject)
+//line cmd/project_yap.gox:110:1
+	this.Post("/api/v1/projects", saveProject)
+//line cmd/project_yap.gox:111:1
+	this.Put("/api/v1/projects/:id", saveProject)
+//line cmd/project_yap.gox:113:1
+	buildProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:114:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:115:1
+		res, err := this.p.Build(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:116:1
+		if err != nil {
+//line cmd/project_yap.gox:117:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:118:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:119:1
+			return
+		}
+//line cmd/project_yap.gox:121:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:123:1
+	projectRoutes.POST("/project/:id/build", buildProject)
+//line cmd/project_yap.gox:124:1
+	this.Post("/api/v1/projects/:id/build", buildProject)
+//line cmd/project_yap.gox:126:1
+	exportProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:127:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:128:1
+		format := ctx.Param("format")
+//line cmd/project_yap.gox:129:1
+		data, mime, err := this.p.ExportProject(ctx.Context(), id, core.UserID(ctx.Request), format)
+//line cmd/project_yap.gox:130:1
+		if err != nil {
+//line cmd/project_yap.gox:131:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:132:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:133:1
+			return
+		}
+//line cmd/project_yap.gox:135:1
+		ctx.Binary__0(200, mime, data)
+	}
+//line cmd/project_yap.gox:137:1
+	projectRoutes.GET("/project/:id/export", exportProject)
+//line cmd/project_yap.gox:138:1
+	this.Get("/api/v1/projects/:id/export", exportProject)
+//line cmd/project_yap.gox:140:1
+	listRevisions := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:141:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:142:1
+		revs, err := this.p.Revisions(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:143:1
+		if err != nil {
+//line cmd/project_yap.gox:144:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:145:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:146:1
+			return
+		}
+//line cmd/project_yap.gox:148:1
+		ctx.Json__1(core.OK(revs))
+	}
+//line cmd/project_yap.gox:150:1
+	projectRoutes.GET("/project/:id/revisions", listRevisions)
+//line cmd/project_yap.gox:151:1
+	this.Get("/api/v1/projects/:id/revisions", listRevisions)
+//line cmd/project_yap.gox:153:1
+	diffProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:154:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:155:1
+		from := ctx.Param("from")
+//line cmd/project_yap.gox:156:1
+		to := ctx.Param("to")
+//line cmd/project_yap.gox:157:1
+		res, err := this.p.Diff(ctx.Context(), id, core.UserID(ctx.Request), from, to)
+//line cmd/project_yap.gox:158:1
+		if err != nil {
+//line cmd/project_yap.gox:159:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:160:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:161:1
+			return
+		}
+//line cmd/project_yap.gox:163:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:165:1
+	projectRoutes.GET("/project/:id/diff", diffProject)
+//line cmd/project_yap.gox:166:1
+	this.Get("/api/v1/projects/:id/diff", diffProject)
+//line cmd/project_yap.gox:168:1
+	forkProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:169:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:170:1
+		uid := core.UserID(ctx.Request)
+//line cmd/project_yap.gox:171:1
+		res, err := this.p.Fork(ctx.Context(), id, uid)
+//line cmd/project_yap.gox:172:1
+		if err != nil {
+//line cmd/project_yap.gox:173:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:174:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:175:1
+			return
+		}
+//line cmd/project_yap.gox:177:1
+		res.Address = this.conf.QiniuPath + res.Address
+//line cmd/project_yap.gox:178:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:180:1
+	projectRoutes.POST("/project/:id/fork", forkProject)
+//line cmd/project_yap.gox:181:1
+	this.Post("/api/v1/projects/:id/forks", forkProject)
+//line cmd/project_yap.gox:183:1
+	listForks := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:184:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:185:1
+		tree, err := this.p.ForkTree(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:186:1
+		if err != nil {
+//line cmd/project_yap.gox:187:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:188:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:189:1
+			return
+		}
+//line cmd/project_yap.gox:191:1
+		ctx.Json__1(core.OK(tree))
+	}
+//line cmd/project_yap.gox:193:1
+	projectRoutes.GET("/project/:id/forks", listForks)
+//line cmd/project_yap.gox:194:1
+	this.Get("/api/v1/projects/:id/forks", listForks)
+//line cmd/project_yap.gox:196:1
+	setVisibility := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:197:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:198:1
+		visibility := ctx.FormValue("visibility")
+//line cmd/project_yap.gox:199:1
+		res, err := this.p.SetVisibility(ctx.Context(), id, core.UserID(ctx.Request), visibility)
+//line cmd/project_yap.gox:200:1
+		if err != nil {
+//line cmd/project_yap.gox:201:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:202:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:203:1
+			return
+		}
+//line cmd/project_yap.gox:205:1
+		res.Address = this.conf.QiniuPath + res.Address
+//line cmd/project_yap.gox:206:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:208:1
+	projectRoutes.PUT("/project/:id/visibility", setVisibility)
+//line cmd/project_yap.gox:209:1
+	this.Put("/api/v1/projects/:id/visibility", setVisibility)
+//line cmd/project_yap.gox:211:1
+	shareProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:212:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:213:1
+		share, err := this.p.ShareProject(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:214:1
+		if err != nil {
+//line cmd/project_yap.gox:215:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:216:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:217:1
+			return
+		}
+//line cmd/project_yap.gox:219:1
+		ctx.Json__1(core.OK(share))
+	}
+//line cmd/project_yap.gox:221:1
+	projectRoutes.POST("/project/:id/share", shareProject)
+//line cmd/project_yap.gox:222:1
+	this.Post("/api/v1/projects/:id/share", shareProject)
+//line cmd/project_yap.gox:224:1
+	unshareProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:225:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:226:1
+		err := this.p.UnshareProject(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:227:1
+		if err != nil {
+//line cmd/project_yap.gox:228:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:229:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:230:1
+			return
+		}
+//line cmd/project_yap.gox:232:1
+		ctx.Json__1(core.OK(nil))
+	}
+//line cmd/project_yap.gox:234:1
+	projectRoutes.DELETE("/project/:id/share", unshareProject)
+//line cmd/project_yap.gox:235:1
+	this.Delete("/api/v1/projects/:id/share", unshareProject)
+//line cmd/project_yap.gox:237:1
+	listComments := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:238:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:239:1
+		res, err := this.p.Comments(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:240:1
+		if err != nil {
+//line cmd/project_yap.gox:241:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:242:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:243:1
+			return
+		}
+//line cmd/project_yap.gox:245:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:247:1
+	projectRoutes.GET("/project/:id/comments", listComments)
+//line cmd/project_yap.gox:248:1
+	this.Get("/api/v1/projects/:id/comments", listComments)
+//line cmd/project_yap.gox:250:1
+	addComment := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:251:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:252:1
+		parentID := ctx.FormValue("parentId")
+//line cmd/project_yap.gox:253:1
+		body := ctx.FormValue("body")
+//line cmd/project_yap.gox:254:1
+		res, err := this.p.AddComment(ctx.Context(), id, core.UserID(ctx.Request), parentID, body)
+//line cmd/project_yap.gox:255:1
+		if err != nil {
+//line cmd/project_yap.gox:256:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:257:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:258:1
+			return
+		}
+//line cmd/project_yap.gox:260:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:262:1
+	projectRoutes.POST("/project/:id/comments", addComment)
+//line cmd/project_yap.gox:263:1
+	this.Post("/api/v1/projects/:id/comments", addComment)
+//line cmd/project_yap.gox:265:1
+	editComment := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:266:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:267:1
+		body := ctx.FormValue("body")
+//line cmd/project_yap.gox:268:1
+		res, err := this.p.EditComment(ctx.Context(), id, core.UserID(ctx.Request), body)
+//line cmd/project_yap.gox:269:1
+		if err != nil {
+//line cmd/project_yap.gox:270:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:271:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:272:1
+			return
+		}
+//line cmd/project_yap.gox:274:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:276:1
+	this.Put("/comment/:id", editComment)
+//line cmd/project_yap.gox:277:1
+	this.Put("/api/v1/comments/:id", editComment)
+//line cmd/project_yap.gox:279:1
+	deleteComment := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:280:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:281:1
+		err := this.p.DeleteComment(ctx.Context(), id, core.UserID(ctx.Request))
+//line cmd/project_yap.gox:282:1
+		if err != nil {
+//line cmd/project_yap.gox:283:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:284:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:285:1
+			return
+		}
+//line cmd/project_yap.gox:287:1
+		ctx.Json__1(core.OK(nil))
+	}
+//line cmd/project_yap.gox:289:1
+	this.Delete("/comment/:id", deleteComment)
+//line cmd/project_yap.gox:290:1
+	this.Delete("/api/v1/comments/:id", deleteComment)
+//line cmd/project_yap.gox:292:1
+	reportComment := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:293:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:294:1
+		reason := ctx.FormValue("reason")
+//line cmd/project_yap.gox:295:1
+		err := this.p.ReportComment(ctx.Context(), id, core.UserID(ctx.Request), core.ClientIP(ctx.Request), reason)
+//line cmd/project_yap.gox:296:1
+		if err != nil {
+//line cmd/project_yap.gox:297:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:298:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:299:1
+			return
+		}
+//line cmd/project_yap.gox:301:1
+		ctx.Json__1(core.OK(nil))
+	}
+//line cmd/project_yap.gox:303:1
+	this.Post("/comment/:id/report", reportComment)
+//line cmd/project_yap.gox:304:1
+	this.Post("/api/v1/comments/:id/report", reportComment)
+//line cmd/project_yap.gox:307:1
+	sharedProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:308:1
+		res, err := this.p.SharedProject(ctx.Context(), ctx.Param("token"))
+//line cmd/project_yap.gox:309:1
+		if err != nil {
+//line cmd/project_yap.gox:310:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:311:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:312:1
+			return
+		}
+//line cmd/project_yap.gox:314:1
+		res.Address = this.conf.QiniuPath + res.Address
+//line cmd/project_yap.gox:315:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:317:1
+	this.Get("/s/:token", sharedProject)
+//line cmd/project_yap.gox:318:1
+	this.Get("/api/v1/shares/:token", sharedProject)
+//line cmd/project_yap.gox:320:1
+	importProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:321:1
+		uid := core.UserID(ctx.Request)
+//line cmd/project_yap.gox:322:1
+		name := ctx.FormValue("name")
+//line cmd/project_yap.gox:323:1
+		body := ctx.FormValue("body")
+//line cmd/project_yap.gox:324:1
+		codeFile := &core.CodeFile{Name: name, AuthorId: uid}
+//line cmd/project_yap.gox:328:1
+		res, err := this.p.ImportProject(ctx.Context(), codeFile, []byte(body))
+//line cmd/project_yap.gox:329:1
+		if err != nil {
+//line cmd/project_yap.gox:330:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:331:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:332:1
+			return
+		}
+//line cmd/project_yap.gox:334:1
+		res.Address = this.conf.QiniuPath + res.Address
+//line cmd/project_yap.gox:335:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:337:1
+	this.Post("/project/import", importProject)
+//line cmd/project_yap.gox:338:1
+	this.Post("/api/v1/imports", importProject)
+//line cmd/project_yap.gox:342:1
+	importSb3 := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:343:1
+		file, header, err := ctx.FormFile("file")
+//line cmd/project_yap.gox:344:1
+		if err != nil {
+//line cmd/project_yap.gox:345:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:346:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:347:1
+			return
+		}
+//line cmd/project_yap.gox:349:1
+		codeFile := &core.CodeFile{Name: ctx.FormValue("name"), AuthorId: core.UserID(ctx.Request), Visibility: ctx.FormValue("visibility")}
+//line cmd/project_yap.gox:354:1
+		res, err := this.p.ImportSb3(ctx.Context(), codeFile, file, header)
+//line cmd/project_yap.gox:355:1
+		if err != nil {
+//line cmd/project_yap.gox:356:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:357:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:358:1
+			return
+		}
+//line cmd/project_yap.gox:360:1
+		res.Project.Address = this.conf.QiniuPath + res.Project.Address
+//line cmd/project_yap.gox:361:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:363:1
+	this.Post("/project/import/sb3", importSb3)
+//line cmd/project_yap.gox:364:1
+	this.Post("/api/v1/imports/sb3", importSb3)
+//line cmd/project_yap.gox:367:1
+	formatCode := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:368:1
+		ctx.ResponseWriter.Header().Set("Content-Type", "application/json")
+//line cmd/project_yap.gox:369:1
+		body := ctx.FormValue("body")
+//line cmd/project_yap.gox:370:1
+		imports := ctx.FormValue("import")
+//line cmd/project_yap.gox:371:1
+		res, err := this.p.CodeFmt(ctx.Context(), body, imports)
+//line cmd/project_yap.gox:372:1
+		if err != nil {
+//line cmd/project_yap.gox:373:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:374:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:375:1
+			return
+		}
+//line cmd/project_yap.gox:377:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:379:1
+	this.Post("/project/fmt", formatCode)
+//line cmd/project_yap.gox:380:1
+	this.Post("/api/v1/format", formatCode)
+//line cmd/project_yap.gox:382:1
+	this.Get("/api/v1/assets", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:383:1
+		page, pageSize := ctx.Param("page"), ctx.Param("pageSize")
+//line cmd/project_yap.gox:384:1
+		if page == "" {
+//line cmd/project_yap.gox:385:1
+			page = "1"
+		}
+//line cmd/project_yap.gox:387:1
+		if pageSize == "" {
+//line cmd/project_yap.gox:388:1
+			pageSize = "20"
+		}
+//line cmd/project_yap.gox:390:1
+		result, err := this.p.AssetList(ctx.Context(), page, pageSize, ctx.Param("type"), ctx.Param("sort"))
+//line cmd/project_yap.gox:391:1
+		if err != nil {
+//line cmd/project_yap.gox:392:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:393:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:394:1
+			return
+		}
+//line cmd/project_yap.gox:396:1
+		ctx.Json__1(core.OK(result))
+	})
+//line cmd/project_yap.gox:399:1
+	this.Get("/api/v1/assets/:id", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:400:1
+		asset, err := this.p.Asset(ctx.Context(), ctx.Param("id"))
+//line cmd/project_yap.gox:401:1
+		if err != nil {
+//line cmd/project_yap.gox:402:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:403:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:404:1
+			return
+		}
+//line cmd/project_yap.gox:406:1
+		this.p.RecordAssetView(ctx.Context(), asset, core.UserID(ctx.Request), core.ClientIP(ctx.Request))
+//line cmd/project_yap.gox:407:1
+		ctx.Json__1(core.OK(asset))
+	})
+//line cmd/project_yap.gox:410:1
+	createAsset := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:411:1
+		isPublic := 0
+//line cmd/project_yap.gox:412:1
+		if ctx.FormValue("isPublic") == "1" {
+//line cmd/project_yap.gox:413:1
+			isPublic = 1
+		}
+//line cmd/project_yap.gox:415:1
+		asset := &core.Asset{Name: ctx.FormValue("name"), AuthorId: core.UserID(ctx.Request), Category: ctx.FormValue("category"), IsPublic: isPublic, AssetType: ctx.FormValue("assetType")}
+//line cmd/project_yap.gox:422:1
+		res, err := this.p.CreateAsset(ctx.Context(), asset, ctx.MultipartForm)
+//line cmd/project_yap.gox:423:1
+		if err != nil {
+//line cmd/project_yap.gox:424:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:425:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:426:1
+			return
+		}
+//line cmd/project_yap.gox:428:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:430:1
+	this.Post("/asset", createAsset)
+//line cmd/project_yap.gox:431:1
+	this.Post("/api/v1/assets", createAsset)
+//line cmd/project_yap.gox:433:1
+	likeProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:434:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:435:1
+		res, err := this.p.LikeProject(ctx.Context(), id, core.UserID(ctx.Request), core.ClientIP(ctx.Request), true)
+//line cmd/project_yap.gox:436:1
+		if err != nil {
+//line cmd/project_yap.gox:437:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:438:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:439:1
+			return
+		}
+//line cmd/project_yap.gox:441:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:443:1
+	projectRoutes.POST("/project/:id/like", likeProject)
+//line cmd/project_yap.gox:444:1
+	this.Post("/api/v1/projects/:id/like", likeProject)
+//line cmd/project_yap.gox:446:1
+	unlikeProject := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:447:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:448:1
+		res, err := this.p.LikeProject(ctx.Context(), id, core.UserID(ctx.Request), core.ClientIP(ctx.Request), false)
+//line cmd/project_yap.gox:449:1
+		if err != nil {
+//line cmd/project_yap.gox:450:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:451:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:452:1
+			return
+		}
+//line cmd/project_yap.gox:454:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:456:1
+	projectRoutes.DELETE("/project/:id/like", unlikeProject)
+//line cmd/project_yap.gox:457:1
+	this.Delete("/api/v1/projects/:id/like", unlikeProject)
+//line cmd/project_yap.gox:459:1
+	favoriteAsset := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:460:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:461:1
+		res, err := this.p.FavoriteAsset(ctx.Context(), id, core.UserID(ctx.Request), core.ClientIP(ctx.Request), true)
+//line cmd/project_yap.gox:462:1
+		if err != nil {
+//line cmd/project_yap.gox:463:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:464:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:465:1
+			return
+		}
+//line cmd/project_yap.gox:467:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:469:1
+	this.Post("/asset/:id/favorite", favoriteAsset)
+//line cmd/project_yap.gox:470:1
+	this.Post("/api/v1/assets/:id/favorite", favoriteAsset)
+//line cmd/project_yap.gox:472:1
+	unfavoriteAsset := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:473:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:474:1
+		res, err := this.p.FavoriteAsset(ctx.Context(), id, core.UserID(ctx.Request), core.ClientIP(ctx.Request), false)
+//line cmd/project_yap.gox:475:1
+		if err != nil {
+//line cmd/project_yap.gox:476:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:477:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:478:1
+			return
+		}
+//line cmd/project_yap.gox:480:1
+		ctx.Json__1(core.OK(res))
+	}
+//line cmd/project_yap.gox:482:1
+	this.Delete("/asset/:id/favorite", unfavoriteAsset)
+//line cmd/project_yap.gox:483:1
+	this.Delete("/api/v1/assets/:id/favorite", unfavoriteAsset)
+//line cmd/project_yap.gox:485:1
+	favorites := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:486:1
+		page, pageSize := ctx.Param("page"), ctx.Param("pageSize")
+//line cmd/project_yap.gox:487:1
+		if page == "" {
+//line cmd/project_yap.gox:488:1
+			page = "1"
+		}
+//line cmd/project_yap.gox:490:1
+		if pageSize == "" {
+//line cmd/project_yap.gox:491:1
+			pageSize = "20"
+		}
+//line cmd/project_yap.gox:493:1
+		result, err := this.p.Favorites(ctx.Context(), core.UserID(ctx.Request), page, pageSize)
+//line cmd/project_yap.gox:494:1
+		if err != nil {
+//line cmd/project_yap.gox:495:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:496:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:497:1
+			return
+		}
+//line cmd/project_yap.gox:499:1
+		ctx.Json__1(core.OK(result))
+	}
+//line cmd/project_yap.gox:501:1
+	this.Get("/favorites", favorites)
+//line cmd/project_yap.gox:502:1
+	this.Get("/api/v1/favorites", favorites)
+//line cmd/project_yap.gox:504:1
+	gallery := func(ctx *yap.Context) {
+//line cmd/project_yap.gox:505:1
+		page, pageSize := ctx.Param("page"), ctx.Param("pageSize")
+//line cmd/project_yap.gox:506:1
+		if page == "" {
+//line cmd/project_yap.gox:507:1
+			page = "1"
+		}
+//line cmd/project_yap.gox:509:1
+		if pageSize == "" {
+//line cmd/project_yap.gox:510:1
+			pageSize = "20"
+		}
+//line cmd/project_yap.gox:512:1
+		result, err := this.p.Gallery(ctx.Context(), ctx.Param("sort"), page, pageSize)
+//line cmd/project_yap.gox:513:1
+		if err != nil {
+//line cmd/project_yap.gox:514:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:515:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:516:1
+			return
+		}
+//line cmd/project_yap.gox:518:1
+		ctx.Json__1(core.OK(result))
+	}
+//line cmd/project_yap.gox:520:1
+	this.Get("/gallery", gallery)
+//line cmd/project_yap.gox:521:1
+	this.Get("/api/v1/gallery", gallery)
+//line cmd/project_yap.gox:523:1
+	this.Post("/api/v1/classes", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:524:1
+		res, err := this.p.CreateClass(ctx.Context(), core.UserID(ctx.Request), ctx.FormValue("name"))
+//line cmd/project_yap.gox:525:1
+		if err != nil {
+//line cmd/project_yap.gox:526:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:527:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:528:1
+			return
+		}
+//line cmd/project_yap.gox:530:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:533:1
+	this.Get("/api/v1/classes", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:534:1
+		res, err := this.p.Classes(ctx.Context(), core.UserID(ctx.Request))
+//line cmd/project_yap.gox:535:1
+		if err != nil {
+//line cmd/project_yap.gox:536:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:537:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:538:1
+			return
+		}
+//line cmd/project_yap.gox:540:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:543:1
+	this.Get("/api/v1/classes/:id", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:544:1
+		res, err := this.p.Class(ctx.Context(), ctx.Param("id"), core.UserID(ctx.Request))
+//line cmd/project_yap.gox:545:1
+		if err != nil {
+//line cmd/project_yap.gox:546:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:547:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:548:1
+			return
+		}
+//line cmd/project_yap.gox:550:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:554:1
+	this.Post("/api/v1/enrollments", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:555:1
+		res, err := this.p.JoinClass(ctx.Context(), ctx.FormValue("code"), core.UserID(ctx.Request))
+//line cmd/project_yap.gox:556:1
+		if err != nil {
+//line cmd/project_yap.gox:557:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:558:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:559:1
+			return
+		}
+//line cmd/project_yap.gox:561:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:564:1
+	this.Delete("/api/v1/classes/:id/students/:student", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:565:1
+		err := this.p.RemoveStudent(ctx.Context(), ctx.Param("id"), core.UserID(ctx.Request), ctx.Param("student"))
+//line cmd/project_yap.gox:566:1
+		if err != nil {
+//line cmd/project_yap.gox:567:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:568:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:569:1
+			return
+		}
+//line cmd/project_yap.gox:571:1
+		ctx.Json__1(core.OK(nil))
+	})
+//line cmd/project_yap.gox:574:1
+	this.Post("/api/v1/classes/:id/assignments", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:575:1
+		id := ctx.Param("id")
+//line cmd/project_yap.gox:576:1
+		title := ctx.FormValue("title")
+//line cmd/project_yap.gox:577:1
+		description := ctx.FormValue("description")
+//line cmd/project_yap.gox:578:1
+		starterID := ctx.FormValue("starterId")
+//line cmd/project_yap.gox:579:1
+		due := ctx.FormValue("due")
+//line cmd/project_yap.gox:580:1
+		res, err := this.p.CreateAssignment(ctx.Context(), id, core.UserID(ctx.Request), title, description, starterID, due)
+//line cmd/project_yap.gox:581:1
+		if err != nil {
+//line cmd/project_yap.gox:582:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:583:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:584:1
+			return
+		}
+//line cmd/project_yap.gox:586:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:589:1
+	this.Get("/api/v1/classes/:id/assignments", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:590:1
+		res, err := this.p.Assignments(ctx.Context(), ctx.Param("id"), core.UserID(ctx.Request))
+//line cmd/project_yap.gox:591:1
+		if err != nil {
+//line cmd/project_yap.gox:592:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:593:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:594:1
+			return
+		}
+//line cmd/project_yap.gox:596:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:599:1
+	this.Post("/api/v1/assignments/:id/accept", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:600:1
+		res, err := this.p.AcceptAssignment(ctx.Context(), ctx.Param("id"), core.UserID(ctx.Request))
+//line cmd/project_yap.gox:601:1
+		if err != nil {
+//line cmd/project_yap.gox:602:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:603:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:604:1
+			return
+		}
+//line cmd/project_yap.gox:606:1
+		res.Address = this.conf.QiniuPath + res.Address
+//line cmd/project_yap.gox:607:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:610:1
+	this.Get("/api/v1/assignments/:id/submissions", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:611:1
+		res, err := this.p.Submissions(ctx.Context(), ctx.Param("id"), core.UserID(ctx.Request))
+//line cmd/project_yap.gox:612:1
+		if err != nil {
+//line cmd/project_yap.gox:613:1
+			code := core.ErrorStatus(ctx.Context(), err)
+//line cmd/project_yap.gox:614:1
+			ctx.Json__0(code, core.ErrorBody(code, err))
+//line cmd/project_yap.gox:615:1
+			return
+		}
+//line cmd/project_yap.gox:617:1
+		ctx.Json__1(core.OK(res))
+	})
+//line cmd/project_yap.gox:620:1
+	this.Get("/api/v1/openapi.json", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:621:1
+		ctx.Binary__0(200, "application/json", core.OpenAPI())
+	})
+//line cmd/project_yap.gox:624:1
+	this.Get("/healthz", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:625:1
+		ctx.Json__1(core.OK(nil))
+	})
+//line cmd/project_yap.gox:628:1
+	this.Get("/readyz", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:629:1
+		if err := this.p.Ready(ctx.Context()); err != nil {
+//line cmd/project_yap.gox:630:1
+			ctx.Json__0(503, core.ErrorBody(503, err))
+//line cmd/project_yap.gox:631:1
+			return
+		}
+//line cmd/project_yap.gox:633:1
+		ctx.Json__1(core.OK(nil))
+	})
+//line cmd/project_yap.gox:636:1
+	this.Get("/metrics", func(ctx *yap.Context) {
+//line cmd/project_yap.gox:637:1
+		this.p.MetricsHandler().ServeHTTP(ctx.ResponseWriter, ctx.Request)
+	})
+//line cmd/project_yap.gox:640:1
+	this.handler = this.p.Instrument(this.p.LogRequests(this.p.CORS(this.p.RateLimit(this.p.ParseForms(this.Engine)))))
+//line cmd/project_yap.gox:641:1
+	if !standalone {
+//line cmd/project_yap.gox:642:1
+		return
+	}
+//line cmd/project_yap.gox:647:1
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+//line cmd/project_yap.gox:648:1
+	defer stop()
+//line cmd/project_yap.gox:649:1
+	if err := core.Serve(ctx, this.conf, this.handler); err != nil {
+//line cmd/project_yap.gox:650:1
+		log.Println(err)
+	}
+//line cmd/project_yap.gox:652:1
+	if err := this.p.Close(); err != nil {
+//line cmd/project_yap.gox:653:1
+		log.Println(err)
+	}
 }
 func main() {
 	yap.Gopt_App_Main(new(project))
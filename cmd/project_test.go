@@ -0,0 +1,1223 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mrkuib/spx-back/internal/core"
+	"github.com/Mrkuib/spx-back/internal/coretest"
+	"github.com/goplus/yap"
+)
+
+// A testServer serves the routes of project_yap.gox, middlewares
+// included, on top of a coretest.Env.
+type testServer struct {
+	*coretest.Env
+	t *testing.T
+	h http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	env := coretest.New(t)
+	app := &project{p: env.Project, conf: env.Conf}
+	yap.Gopt_App_Main(app)
+	return &testServer{Env: env, t: t, h: app.handler}
+}
+
+// A response is the envelope of a JSON response, see core.Response.
+type response struct {
+	Code int
+	Msg  string
+	Data json.RawMessage
+}
+
+func (s *testServer) do(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.h.ServeHTTP(w, req)
+	return w
+}
+
+// call sends a request and decodes the JSON response into data, checking
+// that it has status code.
+func (s *testServer) call(req *http.Request, code int, data interface{}) *response {
+	s.t.Helper()
+	w := s.do(req)
+	var res response
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		s.t.Fatalf("%s %s: %v in %q", req.Method, req.URL, err, w.Body)
+	}
+	if w.Code != code || res.Code != code {
+		s.t.Fatalf("%s %s: status %d, code %d (%s), want %d", req.Method, req.URL, w.Code, res.Code, res.Msg, code)
+	}
+	if data != nil {
+		if err := json.Unmarshal(res.Data, data); err != nil {
+			s.t.Fatalf("%s %s: %v in %s", req.Method, req.URL, err, res.Data)
+		}
+	}
+	return &res
+}
+
+func (s *testServer) get(path string, code int, data interface{}) *response {
+	s.t.Helper()
+	return s.call(httptest.NewRequest("GET", path, nil), code, data)
+}
+
+// form returns a multipart request with the given fields, and a file
+// field when bundle isn't empty.
+func form(method, path string, fields map[string]string, bundle string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if bundle != "" {
+		fw, _ := mw.CreateFormFile("file", "main.txtar")
+		io.WriteString(fw, bundle)
+	}
+	mw.Close()
+	req := httptest.NewRequest(method, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func postForm(path string, values url.Values) *http.Request {
+	req := httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// asUser sets the user making req.
+func asUser(req *http.Request, uid string) *http.Request {
+	req.Header.Set("X-User-Id", uid)
+	return req
+}
+
+// from sets the client IP of req, which is 192.0.2.1 by default.
+func from(req *http.Request, ip string) *http.Request {
+	req.RemoteAddr = ip + ":1234"
+	return req
+}
+
+const (
+	bundleV1 = "-- go.mod --\nmodule  demo\n-- main.spx --\nonStart => {\n}\n"
+	bundleV2 = "-- go.mod --\nmodule  demo\n-- main.spx --\nonStart => {\n\tsay \"hi\"\n}\n"
+)
+
+func TestProjectRoutes(t *testing.T) {
+	s := newTestServer(t)
+
+	var created core.CodeFile
+	s.call(form("POST", "/project/save", map[string]string{"name": "demo", "uid": "u1"}, bundleV1), 200, &created)
+	if created.ID == "" || created.Name != "demo" || created.AuthorId != "u1" {
+		t.Fatalf("save: got %+v", created)
+	}
+	if !strings.HasPrefix(created.Address, coretest.QiniuPath) || created.Size != int64(len(bundleV1)) {
+		t.Errorf("save: got address %q, size %d", created.Address, created.Size)
+	}
+	id := created.ID
+
+	for _, path := range []string{"/project/" + id, "/api/v1/projects/" + id} {
+		var got core.CodeFile
+		s.get(path, 200, &got)
+		if got.ID != id || got.Name != "demo" || got.Address != created.Address {
+			t.Errorf("GET %s = %+v, want %+v", path, got, created)
+		}
+	}
+
+	var updated core.CodeFile
+	s.call(form("PUT", "/api/v1/projects/"+id, map[string]string{"name": "demo2", "uid": "u1"}, bundleV2), 200, &updated)
+	if updated.ID != id || updated.Name != "demo2" || updated.Address == created.Address {
+		t.Errorf("update: got %+v", updated)
+	}
+
+	var v1Project core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "other", "uid": "u1"}, bundleV1), 200, &v1Project)
+	if v1Project.ID == "" || v1Project.ID == id {
+		t.Errorf("create: got ID %q", v1Project.ID)
+	}
+
+	var revs []core.Revision
+	for _, path := range []string{"/project/" + id + "/revisions", "/api/v1/projects/" + id + "/revisions"} {
+		s.get(path, 200, &revs)
+		if len(revs) != 2 || revs[0].ProjectId != id {
+			t.Fatalf("GET %s = %+v, want 2 revisions", path, revs)
+		}
+	}
+
+	for _, path := range []string{"/project/" + id + "/diff", "/api/v1/projects/" + id + "/diff"} {
+		var diff core.ProjectDiff
+		s.get(path+"?from="+revs[1].ID, 200, &diff)
+		if len(diff.Files) != 1 || diff.Files[0].Name != "main.spx" || diff.Files[0].Status != "modified" {
+			t.Errorf("GET %s = %+v", path, diff)
+		}
+		s.get(path, 400, nil)
+	}
+
+	for _, path := range []string{"/project/" + id + "/export", "/api/v1/projects/" + id + "/export"} {
+		w := s.do(httptest.NewRequest("GET", path, nil))
+		if w.Code != 200 || w.Body.String() != bundleV2 {
+			t.Errorf("GET %s: status %d, body %q", path, w.Code, w.Body)
+		}
+		w = s.do(httptest.NewRequest("GET", path+"?format=zip", nil))
+		if w.Code != 200 || w.Header().Get("Content-Type") != "application/zip" {
+			t.Errorf("GET %s?format=zip: status %d, type %q", path, w.Code, w.Header().Get("Content-Type"))
+		}
+		s.get(path+"?format=rar", 400, nil)
+	}
+
+	for _, path := range []string{"/project/import", "/api/v1/imports"} {
+		var imported core.CodeFile
+		s.call(postForm(path, url.Values{"name": {"copy"}, "uid": {"u2"}, "body": {bundleV2}}), 200, &imported)
+		if imported.ID == "" || imported.AuthorId != "u2" || !strings.HasPrefix(imported.Address, coretest.QiniuPath) {
+			t.Errorf("POST %s = %+v", path, imported)
+		}
+		w := s.do(httptest.NewRequest("GET", "/api/v1/projects/"+imported.ID+"/export", nil))
+		if w.Body.String() != bundleV2 {
+			t.Errorf("POST %s: exported as %q", path, w.Body)
+		}
+	}
+
+	for _, path := range []string{"/project/404", "/api/v1/projects/404", "/project/404/export", "/api/v1/projects/404/export"} {
+		s.get(path, 404, nil)
+	}
+	for _, path := range []string{"/project/404/build", "/api/v1/projects/404/build"} {
+		s.call(httptest.NewRequest("POST", path, nil), 404, nil)
+	}
+	s.call(form("PUT", "/api/v1/projects/404", map[string]string{"name": "x"}, bundleV1), 404, nil)
+	s.call(form("POST", "/project/save", map[string]string{"name": "x", "uid": "u1"}, ""), 400, nil)
+}
+
+func TestImportAssets(t *testing.T) {
+	s := newTestServer(t)
+	img := base64.StdEncoding.EncodeToString([]byte(pngImage(2, 2)))
+	var saved core.CodeFile
+	s.call(form("POST", "/project/save", map[string]string{"name": "demo", "uid": "u1"}, "-- main.spx --\nonStart => {}\n-- cat.png;base64 --\n"+img+"\n"), 200, &saved)
+	w := s.do(httptest.NewRequest("GET", "/api/v1/projects/"+saved.ID+"/export", nil))
+	if !strings.Contains(w.Body.String(), "-- .assets.json --") {
+		t.Fatalf("exported as %q, want an assets manifest", w.Body)
+	}
+	var imported core.CodeFile
+	s.call(postForm("/api/v1/imports", url.Values{"name": {"copy"}, "uid": {"u2"}, "body": {w.Body.String()}}), 200, &imported)
+	if w2 := s.do(httptest.NewRequest("GET", "/api/v1/projects/"+imported.ID+"/export", nil)); w2.Body.String() != w.Body.String() {
+		t.Errorf("imported project exported as %q, want %q", w2.Body, w.Body)
+	}
+
+	// only assets uploaded by an export may be referenced, not the bundle
+	// of another project
+	for _, u := range []string{saved.Address, coretest.QiniuPath + s.Conf.ProjectPath + "assets/../" + path.Base(saved.Address)} {
+		body := "-- main.spx --\n-- .assets.json --\n{\"cat.zip\": \"" + u + "\"}\n"
+		s.call(postForm("/api/v1/imports", url.Values{"name": {"steal"}, "uid": {"u2"}, "body": {body}}), 400, nil)
+	}
+}
+
+func TestBuildRoutes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds run the gop toolchain")
+	}
+	s := newTestServer(t)
+	var created core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "demo", "uid": "u1"}, bundleV1), 200, &created)
+	for _, path := range []string{"/project/" + created.ID + "/build", "/api/v1/projects/" + created.ID + "/build"} {
+		var res core.BuildResponse
+		s.call(httptest.NewRequest("POST", path, nil), 200, &res)
+		if res.URL == "" && res.Error == "" {
+			t.Errorf("POST %s = %+v, want a URL or an error", path, res)
+		}
+	}
+}
+
+func TestSaveFormat(t *testing.T) {
+	s := newTestServer(t)
+
+	var saved core.CodeFile
+	s.call(form("POST", "/project/save", map[string]string{"name": "demo", "uid": "u1", "format": "1"}, "-- go.mod --\nmodule  demo\n"), 200, &saved)
+	w := s.do(httptest.NewRequest("GET", "/api/v1/projects/"+saved.ID+"/export", nil))
+	if want := "-- go.mod --\nmodule demo\n"; w.Body.String() != want {
+		t.Errorf("formatted bundle = %q, want %q", w.Body, want)
+	}
+
+	var files map[string]core.FormatError
+	res := s.call(form("POST", "/project/save", map[string]string{"name": "demo", "uid": "u1", "format": "1"}, "-- go.mod --\nmodule\n"), 422, &files)
+	if res.Msg != "format failed" || files["go.mod"].Msg == "" {
+		t.Errorf("format errors = %s %+v", res.Msg, files)
+	}
+}
+
+func TestFormatRoutes(t *testing.T) {
+	s := newTestServer(t)
+	for _, path := range []string{"/project/fmt", "/api/v1/format"} {
+		var res core.FormatResponse
+		body := "-- a.go --\npackage a\nfunc F() { fmt.Println() }\n"
+		s.call(postForm(path, url.Values{"body": {body}, "import": {"true"}}), 200, &res)
+		if !strings.Contains(res.Body, `import "fmt"`) || res.Error.Msg != "" {
+			t.Errorf("POST %s = %+v", path, res)
+		}
+
+		body = "-- a.go --\npackage a\nfunc {\n"
+		r := s.call(postForm(path, url.Values{"body": {body}, "import": {"true"}}), 200, &res)
+		if res.Error.Line != 2 || res.Error.Msg == "" {
+			t.Errorf("POST %s = %+v, want an error on line 2", path, res)
+		}
+		if !strings.Contains(string(r.Data), `"error":{"column":`) {
+			t.Errorf("POST %s = %s, want camelCase fields", path, r.Data)
+		}
+
+		s.call(postForm(path, url.Values{"body": {"-- ../a.go --\n"}}), 400, nil)
+	}
+}
+
+func TestAssetRoutes(t *testing.T) {
+	s := newTestServer(t)
+	cat := &core.Asset{
+		Name:      "cat",
+		AuthorId:  "u1",
+		Address:   `{"assets":{"cat.png":"spirit/cat.png"},"indexJson":"spirit/index.json"}`,
+		AssetType: "sprite",
+		Status:    1,
+	}
+	s.AddAsset(t, cat)
+	s.AddAsset(t, &core.Asset{Name: "dog", AuthorId: "u1", Address: `{}`, AssetType: "sprite", Status: 1})
+	s.AddAsset(t, &core.Asset{Name: "sky", AuthorId: "u1", Address: `{}`, AssetType: "backdrop", Status: 1})
+	deleted := s.AddAsset(t, &core.Asset{Name: "gone", AuthorId: "u1", Address: `{}`, AssetType: "sprite", Status: 0})
+
+	wantAddress := core.AssetAddress{
+		Assets:    map[string]string{"cat.png": coretest.QiniuPath + "spirit/cat.png"},
+		IndexJson: coretest.QiniuPath + "spirit/index.json",
+	}
+	var legacy struct{ Asset core.AssetResponse }
+	s.get("/asset/"+cat.ID, 200, &legacy)
+	var v1 core.AssetResponse
+	s.get("/api/v1/assets/"+cat.ID, 200, &v1)
+	for _, got := range []core.AssetResponse{legacy.Asset, v1} {
+		if got.ID != cat.ID || got.Name != "cat" || got.Address == nil ||
+			got.Address.IndexJson != wantAddress.IndexJson || got.Address.Assets["cat.png"] != wantAddress.Assets["cat.png"] {
+			t.Errorf("asset = %+v", got)
+		}
+	}
+	s.get("/asset/"+deleted, 404, nil)
+	s.get("/api/v1/assets/"+deleted, 404, nil)
+
+	for _, path := range []string{"/list/asset/1/1/sprite", "/api/v1/assets?type=sprite&page=1&pageSize=1"} {
+		var page struct {
+			TotalCount int
+			TotalPage  int
+			Data       []core.AssetResponse
+		}
+		s.get(path, 200, &page)
+		if page.TotalCount != 2 || page.TotalPage != 2 || len(page.Data) != 1 || page.Data[0].AssetType != "sprite" {
+			t.Errorf("GET %s = %+v", path, page)
+		}
+	}
+	var page struct{ TotalCount int }
+	s.get("/api/v1/assets?type=backdrop", 200, &page)
+	if page.TotalCount != 1 {
+		t.Errorf("backdrops: TotalCount = %d, want 1", page.TotalCount)
+	}
+	s.get("/list/asset/0/10/sprite", 400, nil)
+	s.get("/list/asset/x/10/sprite", 400, nil)
+	s.get("/api/v1/assets?type=sprite&pageSize=1000", 400, nil)
+}
+
+func TestOperationalRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.get("/healthz", 200, nil)
+	s.get("/readyz", 200, nil)
+
+	w := s.do(httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("GET /metrics: status %d", w.Code)
+	}
+
+	w = s.do(httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]interface{}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc.Paths["/projects/{id}"] == nil {
+		t.Errorf("GET /api/v1/openapi.json: %v, %+v", err, doc)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(httptest.NewRequest("GET", "/healthz", nil))
+	if w.Header().Get("X-Request-Id") == "" {
+		t.Error("no X-Request-Id in response")
+	}
+
+	req := httptest.NewRequest("OPTIONS", "/api/v1/projects", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = s.do(req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("preflight: status %d, headers %v", w.Code, w.Header())
+	}
+
+	// bodies past MAX_UNPACKED_MB are cut off before they are buffered
+	big := "-- main.spx --\n" + strings.Repeat("println 1\n", (s.Conf.MaxUnpackedMB+2)<<17)
+	for _, path := range []string{"/project/save", "/api/v1/projects"} {
+		s.call(form("POST", path, map[string]string{"name": "big", "uid": "u1"}, big), 413, nil)
+	}
+}
+
+func TestForkRoutes(t *testing.T) {
+	s := newTestServer(t)
+	var orig core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "demo", "uid": "u1"}, bundleV1), 200, &orig)
+
+	var fork core.CodeFile
+	s.call(postForm("/project/"+orig.ID+"/fork", url.Values{"uid": {"u2"}}), 200, &fork)
+	if fork.ID == orig.ID || fork.AuthorId != "u2" || fork.ForkedFrom != orig.ID || fork.Address == orig.Address {
+		t.Fatalf("fork = %+v", fork)
+	}
+	w := s.do(httptest.NewRequest("GET", "/api/v1/projects/"+fork.ID+"/export", nil))
+	if w.Body.String() != bundleV1 {
+		t.Errorf("fork exported as %q", w.Body)
+	}
+	var revs []core.Revision
+	s.get("/api/v1/projects/"+fork.ID+"/revisions", 200, &revs)
+	if len(revs) != 1 {
+		t.Errorf("fork has %d revisions, want 1", len(revs))
+	}
+
+	var remix core.CodeFile
+	s.call(postForm("/api/v1/projects/"+fork.ID+"/forks", url.Values{"uid": {"u3"}}), 200, &remix)
+
+	for _, path := range []string{"/project/" + orig.ID + "/forks", "/api/v1/projects/" + orig.ID + "/forks"} {
+		var tree core.ForkNode
+		s.get(path, 200, &tree)
+		if tree.ID != orig.ID || len(tree.Forks) != 1 || tree.Forks[0].ID != fork.ID ||
+			len(tree.Forks[0].Forks) != 1 || tree.Forks[0].Forks[0].ID != remix.ID {
+			t.Errorf("GET %s = %+v", path, tree)
+		}
+	}
+
+	var byHeader core.CodeFile
+	s.call(asUser(postForm("/project/"+orig.ID+"/fork", nil), "u4"), 200, &byHeader)
+	if byHeader.AuthorId != "u4" {
+		t.Errorf("fork by X-User-Id u4 owned by %q", byHeader.AuthorId)
+	}
+	s.call(postForm("/project/"+orig.ID+"/fork", nil), 400, nil)
+	s.call(postForm("/project/404/fork", url.Values{"uid": {"u2"}}), 404, nil)
+	s.get("/api/v1/projects/404/forks", 404, nil)
+}
+
+func TestQuotaRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.Conf.StorageQuotaMB = 1
+	big := bundleV1 + "-- big.txt --\n" + strings.Repeat("x\n", 300<<10)
+
+	var created core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "demo", "uid": "u1"}, big), 200, &created)
+	// The first version is kept for its revision, so saving another
+	// one doesn't fit in the quota.
+	s.call(form("PUT", "/api/v1/projects/"+created.ID, map[string]string{"uid": "u1"}, big+"x\n"), 403, nil)
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "other", "uid": "u2"}, big), 200, nil)
+}
+
+func TestVisibilityRoutes(t *testing.T) {
+	s := newTestServer(t)
+	var created core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "demo", "uid": "u1", "visibility": "private"}, bundleV1), 200, &created)
+	if created.Visibility != core.VisibilityPrivate {
+		t.Fatalf("visibility = %q, want private", created.Visibility)
+	}
+	id := created.ID
+	owner := func(req *http.Request) *http.Request {
+		req.Header.Set("X-User-Id", "u1")
+		return req
+	}
+	stranger := func(req *http.Request) *http.Request {
+		req.Header.Set("X-User-Id", "u2")
+		return req
+	}
+
+	s.call(owner(httptest.NewRequest("GET", "/api/v1/projects/"+id, nil)), 200, nil)
+	for _, path := range []string{"/project/" + id, "/api/v1/projects/" + id + "/export", "/api/v1/projects/" + id + "/revisions", "/api/v1/projects/" + id + "/forks"} {
+		s.call(stranger(httptest.NewRequest("GET", path, nil)), 404, nil)
+		s.get(path, 404, nil)
+	}
+	s.call(stranger(postForm("/api/v1/projects/"+id+"/forks", url.Values{"uid": {"u2"}})), 404, nil)
+
+	var share core.Share
+	s.call(stranger(httptest.NewRequest("POST", "/api/v1/projects/"+id+"/share", nil)), 404, nil)
+	s.call(owner(httptest.NewRequest("POST", "/project/"+id+"/share", nil)), 200, &share)
+	if share.Token == "" || share.Path != "/s/"+share.Token {
+		t.Fatalf("share = %+v", share)
+	}
+	var again core.Share
+	s.call(owner(httptest.NewRequest("POST", "/api/v1/projects/"+id+"/share", nil)), 200, &again)
+	if again != share {
+		t.Errorf("sharing again = %+v, want %+v", again, share)
+	}
+	for _, path := range []string{"/s/" + share.Token, "/api/v1/shares/" + share.Token} {
+		var shared core.CodeFile
+		s.get(path, 200, &shared)
+		if shared.ID != id || shared.ShareToken != "" || !strings.HasPrefix(shared.Address, coretest.QiniuPath) {
+			t.Errorf("GET %s = %+v", path, shared)
+		}
+	}
+	var got core.CodeFile
+	s.call(owner(httptest.NewRequest("GET", "/api/v1/projects/"+id, nil)), 200, &got)
+	if got.ShareToken != share.Token {
+		t.Errorf("owner sees share token %q, want %q", got.ShareToken, share.Token)
+	}
+	s.call(owner(httptest.NewRequest("DELETE", "/api/v1/projects/"+id+"/share", nil)), 200, nil)
+	s.get("/s/"+share.Token, 404, nil)
+
+	req := httptest.NewRequest("PUT", "/api/v1/projects/"+id+"/visibility", strings.NewReader("visibility=public"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.call(stranger(req), 404, nil)
+	req = httptest.NewRequest("PUT", "/project/"+id+"/visibility", strings.NewReader("visibility=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.call(owner(req), 400, nil)
+	req = httptest.NewRequest("PUT", "/api/v1/projects/"+id+"/visibility", strings.NewReader("visibility=public"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.call(owner(req), 200, &got)
+	if got.Visibility != core.VisibilityPublic {
+		t.Errorf("visibility = %q, want public", got.Visibility)
+	}
+	s.call(owner(httptest.NewRequest("POST", "/api/v1/projects/"+id+"/share", nil)), 200, nil)
+	var seen core.CodeFile
+	s.call(stranger(httptest.NewRequest("GET", "/api/v1/projects/"+id, nil)), 200, &seen)
+	if seen.ShareToken != "" {
+		t.Errorf("stranger sees share token %q", seen.ShareToken)
+	}
+	s.call(stranger(httptest.NewRequest("POST", "/api/v1/projects/"+id+"/share", nil)), 403, nil)
+
+	for _, req := range []*http.Request{
+		stranger(form("PUT", "/api/v1/projects/"+id, map[string]string{"name": "mine", "visibility": "private"}, bundleV2)),
+		form("POST", "/project/save?id="+id, map[string]string{"name": "mine", "uid": "u2"}, bundleV2),
+		form("POST", "/project/save?id="+id, map[string]string{"name": "mine"}, bundleV2),
+	} {
+		s.call(req, 403, nil)
+	}
+	var updated core.CodeFile
+	s.call(owner(form("PUT", "/api/v1/projects/"+id, map[string]string{"name": "demo"}, bundleV2)), 200, &updated)
+	if updated.Visibility != core.VisibilityPublic {
+		t.Errorf("visibility after update = %q, want public", updated.Visibility)
+	}
+}
+
+func TestGalleryRoutes(t *testing.T) {
+	s := newTestServer(t)
+	ids := make(map[string]string)
+	for _, name := range []string{"old", "liked", "new", "hidden", "deleted"} {
+		visibility := core.VisibilityPublic
+		if name == "hidden" {
+			visibility = core.VisibilityUnlisted
+		}
+		var c core.CodeFile
+		s.call(form("POST", "/api/v1/projects", map[string]string{"name": name, "uid": "u1", "visibility": visibility}, bundleV1), 200, &c)
+		ids[name] = c.ID
+	}
+	s.DB.Exec("UPDATE project SET c_time = ? WHERE id = ?", time.Now().Add(-100*time.Hour), ids["old"])
+	s.DB.Exec("UPDATE project SET like_count = 2 WHERE id = ?", ids["liked"])
+	s.DB.Exec("UPDATE project SET status = 0, like_count = 9 WHERE id = ?", ids["deleted"])
+	for i := 0; i < 3; i++ {
+		s.get("/api/v1/projects/"+ids["old"], 200, nil)
+	}
+	s.call(func() *http.Request {
+		req := httptest.NewRequest("GET", "/api/v1/projects/"+ids["new"], nil)
+		req.Header.Set("X-User-Id", "u1")
+		return req
+	}(), 200, nil)
+	if err := s.Project.RefreshTrending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(path string) []string {
+		t.Helper()
+		var page struct {
+			TotalCount int
+			Data       []core.GalleryProject
+		}
+		s.get(path, 200, &page)
+		var names []string
+		for _, p := range page.Data {
+			if !strings.HasPrefix(p.Address, coretest.QiniuPath) {
+				t.Errorf("GET %s: address %q", path, p.Address)
+			}
+			names = append(names, p.Name)
+		}
+		if page.TotalCount != 3 {
+			t.Errorf("GET %s: TotalCount = %d, want 3", path, page.TotalCount)
+		}
+		return names
+	}
+	for path, want := range map[string][]string{
+		"/gallery":                                       {"new", "liked", "old"},
+		"/api/v1/gallery?sort=recent":                    {"new", "liked", "old"},
+		"/api/v1/gallery?sort=popular":                   {"liked", "old", "new"},
+		"/api/v1/gallery?sort=trending":                  {"liked", "old", "new"},
+		"/api/v1/gallery?sort=popular&pageSize=1":        {"liked"},
+		"/api/v1/gallery?sort=popular&page=2&pageSize=2": {"new"},
+	} {
+		if got := names(path); !reflect.DeepEqual(got, want) {
+			t.Errorf("GET %s = %q, want %q", path, got, want)
+		}
+	}
+	s.get("/api/v1/gallery?sort=best", 400, nil)
+}
+
+func TestLikeRoutes(t *testing.T) {
+	s := newTestServer(t)
+	var c core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "demo", "uid": "u1"}, bundleV1), 200, &c)
+	like := func(method, path, uid, ip string, want core.LikeState) {
+		t.Helper()
+		var got core.LikeState
+		s.call(from(asUser(httptest.NewRequest(method, path, nil), uid), ip), 200, &got)
+		if got != want {
+			t.Errorf("%s %s as %s at %s = %+v, want %+v", method, path, uid, ip, got, want)
+		}
+	}
+	like("POST", "/project/"+c.ID+"/like", "u2", "192.0.2.2", core.LikeState{Liked: true, LikeCount: 1})
+	like("POST", "/api/v1/projects/"+c.ID+"/like", "u2", "192.0.2.2", core.LikeState{Liked: true, LikeCount: 1})
+	like("POST", "/api/v1/projects/"+c.ID+"/like", "u3", "192.0.2.3", core.LikeState{Liked: true, LikeCount: 2})
+	// made up users at the same address count once
+	like("POST", "/api/v1/projects/"+c.ID+"/like", "u8", "192.0.2.3", core.LikeState{Liked: true, LikeCount: 2})
+	like("POST", "/api/v1/projects/"+c.ID+"/like", "u9", "192.0.2.3", core.LikeState{Liked: true, LikeCount: 2})
+	like("DELETE", "/api/v1/projects/"+c.ID+"/like", "u8", "192.0.2.3", core.LikeState{LikeCount: 2})
+	like("DELETE", "/api/v1/projects/"+c.ID+"/like", "u9", "192.0.2.3", core.LikeState{LikeCount: 2})
+	like("DELETE", "/project/"+c.ID+"/like", "u3", "192.0.2.3", core.LikeState{LikeCount: 1})
+	like("DELETE", "/api/v1/projects/"+c.ID+"/like", "u3", "192.0.2.3", core.LikeState{LikeCount: 1})
+	s.call(httptest.NewRequest("POST", "/api/v1/projects/"+c.ID+"/like", nil), 400, nil)
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/projects/999/like", nil), "u2"), 404, nil)
+
+	// Views are counted once per viewer, and per address, and not at all
+	// for the owner.
+	for i := 0; i < 2; i++ {
+		s.get("/project/"+c.ID, 200, nil)
+		s.call(from(asUser(httptest.NewRequest("GET", "/api/v1/projects/"+c.ID, nil), "u2"), "192.0.2.2"), 200, nil)
+		s.call(from(asUser(httptest.NewRequest("GET", "/api/v1/projects/"+c.ID, nil), "u9"), "192.0.2.2"), 200, nil)
+		s.call(asUser(httptest.NewRequest("GET", "/api/v1/projects/"+c.ID, nil), "u1"), 200, nil)
+	}
+	var got core.CodeFile
+	s.get("/api/v1/projects/"+c.ID, 200, &got)
+	if got.ViewCount != 2 || got.LikeCount != 1 {
+		t.Errorf("viewCount, likeCount = %d, %d, want 2, 1", got.ViewCount, got.LikeCount)
+	}
+}
+
+func TestFavoriteRoutes(t *testing.T) {
+	s := newTestServer(t)
+	ids := make(map[string]string)
+	for _, name := range []string{"cat", "dog", "fox"} {
+		ids[name] = s.AddAsset(t, &core.Asset{Name: name, AuthorId: "u1", Address: `{}`, AssetType: "sprite", Status: 1})
+	}
+	favorite := func(method, path, uid string, want core.FavoriteState) {
+		t.Helper()
+		var got core.FavoriteState
+		s.call(from(asUser(httptest.NewRequest(method, path, nil), uid), "192.0.2."+uid[1:]), 200, &got)
+		if got != want {
+			t.Errorf("%s %s as %s = %+v, want %+v", method, path, uid, got, want)
+		}
+	}
+	favorite("POST", "/asset/"+ids["dog"]+"/favorite", "u2", core.FavoriteState{Favorited: true, FavoriteCount: 1})
+	favorite("POST", "/api/v1/assets/"+ids["fox"]+"/favorite", "u2", core.FavoriteState{Favorited: true, FavoriteCount: 1})
+	favorite("POST", "/api/v1/assets/"+ids["fox"]+"/favorite", "u3", core.FavoriteState{Favorited: true, FavoriteCount: 2})
+	favorite("POST", "/api/v1/assets/"+ids["cat"]+"/favorite", "u3", core.FavoriteState{Favorited: true, FavoriteCount: 1})
+	favorite("DELETE", "/asset/"+ids["cat"]+"/favorite", "u3", core.FavoriteState{FavoriteCount: 0})
+	favorite("DELETE", "/api/v1/assets/"+ids["cat"]+"/favorite", "u3", core.FavoriteState{FavoriteCount: 0})
+	s.call(httptest.NewRequest("POST", "/api/v1/assets/"+ids["cat"]+"/favorite", nil), 400, nil)
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/assets/999/favorite", nil), "u2"), 404, nil)
+
+	names := func(req *http.Request) []string {
+		t.Helper()
+		var page struct {
+			TotalCount int
+			Data       []core.AssetResponse
+		}
+		s.call(req, 200, &page)
+		var names []string
+		for _, a := range page.Data {
+			names = append(names, a.Name)
+		}
+		if page.TotalCount < len(names) {
+			t.Errorf("%s: TotalCount = %d for %d assets", req.URL, page.TotalCount, len(names))
+		}
+		return names
+	}
+	for _, tt := range []struct {
+		req  *http.Request
+		want []string
+	}{
+		{asUser(httptest.NewRequest("GET", "/favorites", nil), "u2"), []string{"fox", "dog"}},
+		{asUser(httptest.NewRequest("GET", "/api/v1/favorites?pageSize=1", nil), "u2"), []string{"fox"}},
+		{asUser(httptest.NewRequest("GET", "/api/v1/favorites", nil), "u3"), []string{"fox"}},
+		{asUser(httptest.NewRequest("GET", "/api/v1/favorites", nil), "u4"), nil},
+	} {
+		if got := names(tt.req); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET %s as %s = %q, want %q", tt.req.URL, tt.req.Header.Get("X-User-Id"), got, tt.want)
+		}
+	}
+	s.get("/api/v1/favorites", 400, nil)
+
+	for i := 0; i < 2; i++ {
+		s.get("/asset/"+ids["cat"], 200, nil)
+		s.call(from(asUser(httptest.NewRequest("GET", "/api/v1/assets/"+ids["cat"], nil), "u2"), "192.0.2.2"), 200, nil)
+		s.call(asUser(httptest.NewRequest("GET", "/api/v1/assets/"+ids["cat"], nil), "u1"), 200, nil)
+	}
+	var cat core.AssetResponse
+	s.get("/api/v1/assets/"+ids["cat"], 200, &cat)
+	if cat.ViewCount != 2 {
+		t.Errorf("viewCount = %d, want 2", cat.ViewCount)
+	}
+
+	for path, want := range map[string][]string{
+		"/list/asset/1/10/sprite?sort=popular":               {"fox", "dog", "cat"},
+		"/api/v1/assets?type=sprite&sort=popular":            {"fox", "dog", "cat"},
+		"/api/v1/assets?type=sprite&sort=views":              {"cat", "fox", "dog"},
+		"/api/v1/assets?type=sprite&sort=recent":             {"fox", "dog", "cat"},
+		"/api/v1/assets?type=sprite&sort=popular&pageSize=1": {"fox"},
+	} {
+		if got := names(httptest.NewRequest("GET", path, nil)); !reflect.DeepEqual(got, want) {
+			t.Errorf("GET %s = %q, want %q", path, got, want)
+		}
+	}
+	s.get("/api/v1/assets?type=sprite&sort=best", 400, nil)
+}
+
+func TestCommentRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.Conf.BlockedWords = "darn, Heck"
+	s.Conf.CommentReportLimit = 2
+	var c core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "demo", "uid": "u1"}, bundleV1), 200, &c)
+	comment := func(path, uid string, values url.Values) *core.Comment {
+		t.Helper()
+		var res core.Comment
+		s.call(asUser(postForm(path, values), uid), 200, &res)
+		return &res
+	}
+	first := comment("/project/"+c.ID+"/comments", "u2", url.Values{"body": {"nice"}})
+	reply := comment("/api/v1/projects/"+c.ID+"/comments", "u1", url.Values{"body": {"thanks"}, "parentId": {first.ID}})
+	second := comment("/api/v1/projects/"+c.ID+"/comments", "u3", url.Values{"body": {"meh"}})
+	if first.AuthorId != "u2" || reply.ParentID != first.ID || second.Status != core.CommentVisible {
+		t.Fatalf("comments = %+v, %+v, %+v", first, reply, second)
+	}
+	for _, tt := range []struct {
+		uid    string
+		values url.Values
+		code   int
+	}{
+		{"", url.Values{"body": {"hi"}}, 400},
+		{"u2", url.Values{"body": {"  "}}, 400},
+		{"u2", url.Values{"body": {"what the HECK"}}, 400},
+		{"u2", url.Values{"body": {strings.Repeat("a", 2001)}}, 413},
+		{"u2", url.Values{"body": {"hi"}, "parentId": {"999"}}, 400},
+	} {
+		s.call(asUser(postForm("/api/v1/projects/"+c.ID+"/comments", tt.values), tt.uid), tt.code, nil)
+	}
+
+	edit := func(id, uid, body string, code int) {
+		t.Helper()
+		req := postForm("/api/v1/comments/"+id, url.Values{"body": {body}})
+		req.Method = "PUT"
+		s.call(asUser(req, uid), code, nil)
+	}
+	edit(first.ID, "u2", "very nice", 200)
+	edit(first.ID, "u1", "rude", 403)
+	edit(first.ID, "u2", "darn", 400)
+
+	// The owner of the project deletes a comment with a reply, which
+	// stays as a placeholder.
+	s.call(asUser(httptest.NewRequest("DELETE", "/comment/"+first.ID, nil), "u3"), 403, nil)
+	s.call(asUser(httptest.NewRequest("DELETE", "/api/v1/comments/"+first.ID, nil), "u1"), 200, nil)
+	s.call(asUser(httptest.NewRequest("DELETE", "/api/v1/comments/"+first.ID, nil), "u1"), 404, nil)
+
+	report := func(uid string) {
+		t.Helper()
+		s.call(from(asUser(postForm("/api/v1/comments/"+second.ID+"/report", url.Values{"reason": {"spam"}}), uid), "192.0.2."+uid[1:]), 200, nil)
+	}
+	report("u2")
+	report("u2")
+	// made up users at the address of u2 don't add up
+	for _, uid := range []string{"u7", "u8", "u9"} {
+		s.call(from(asUser(postForm("/comment/"+second.ID+"/report", nil), uid), "192.0.2.2"), 200, nil)
+	}
+	threads := func(uid string) []*core.Comment {
+		t.Helper()
+		var res []*core.Comment
+		s.call(asUser(httptest.NewRequest("GET", "/project/"+c.ID+"/comments", nil), uid), 200, &res)
+		return res
+	}
+	if got := threads("u4"); len(got) != 2 || got[1].Status != core.CommentVisible || got[1].Body != "meh" {
+		t.Fatalf("threads after one report = %+v", got)
+	}
+	report("u4")
+
+	got := threads("u4")
+	if len(got) != 2 {
+		t.Fatalf("threads = %+v", got)
+	}
+	if got[0].ID != first.ID || got[0].Body != "" || got[0].Status != core.CommentDeleted ||
+		len(got[0].Replies) != 1 || got[0].Replies[0].Body != "thanks" {
+		t.Errorf("deleted thread = %+v", got[0])
+	}
+	if got[1].ID != second.ID || got[1].Body != "" || got[1].Status != core.CommentHidden {
+		t.Errorf("hidden comment = %+v", got[1])
+	}
+	if owner := threads("u1"); owner[1].Body != "meh" {
+		t.Errorf("owner sees hidden comment as %+v", owner[1])
+	}
+
+	// Deleting the reply leaves nothing of the first thread.
+	s.call(asUser(httptest.NewRequest("DELETE", "/api/v1/comments/"+reply.ID, nil), "u1"), 200, nil)
+	if got := threads("u4"); len(got) != 1 || got[0].ID != second.ID {
+		t.Errorf("threads = %+v", got)
+	}
+
+	// Comments on a private project are as private as the project.
+	s.call(asUser(form("PUT", "/api/v1/projects/"+c.ID+"/visibility", map[string]string{"visibility": "private"}, ""), "u1"), 200, nil)
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/projects/"+c.ID+"/comments", nil), "u2"), 404, nil)
+	s.call(asUser(postForm("/api/v1/projects/"+c.ID+"/comments", url.Values{"body": {"hi"}}), "u2"), 404, nil)
+	edit(second.ID, "u3", "better", 404)
+}
+
+func TestClassRoutes(t *testing.T) {
+	s := newTestServer(t)
+	var starter core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "starter", "uid": "teacher", "visibility": "private"}, bundleV1), 200, &starter)
+
+	var class core.Class
+	s.call(asUser(postForm("/api/v1/classes", url.Values{"name": {"Art 101"}}), "teacher"), 200, &class)
+	if class.TeacherId != "teacher" || len(class.JoinCode) != 8 {
+		t.Fatalf("class = %+v", class)
+	}
+	s.call(postForm("/api/v1/classes", url.Values{"name": {"Art 101"}}), 400, nil)
+	for _, student := range []string{"s1", "s2"} {
+		var joined core.Class
+		s.call(asUser(postForm("/api/v1/enrollments", url.Values{"code": {strings.ToLower(class.JoinCode)}}), student), 200, &joined)
+		if joined.ID != class.ID || joined.JoinCode != "" || joined.Students != nil {
+			t.Errorf("%s joined %+v", student, joined)
+		}
+	}
+	s.call(asUser(postForm("/api/v1/enrollments", url.Values{"code": {class.JoinCode}}), "s1"), 200, nil)
+	s.call(asUser(postForm("/api/v1/enrollments", url.Values{"code": {"NOPE"}}), "s3"), 400, nil)
+	s.call(asUser(postForm("/api/v1/enrollments", url.Values{"code": {class.JoinCode}}), "teacher"), 400, nil)
+	var got core.Class
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/classes/"+class.ID, nil), "teacher"), 200, &got)
+	if !reflect.DeepEqual(got.Students, []string{"s1", "s2"}) {
+		t.Errorf("students = %q", got.Students)
+	}
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/classes/"+class.ID, nil), "s3"), 404, nil)
+	var classes []core.Class
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/classes", nil), "s2"), 200, &classes)
+	if len(classes) != 1 || classes[0].ID != class.ID {
+		t.Errorf("classes of s2 = %+v", classes)
+	}
+
+	assign := func(uid string, values url.Values, code int) *core.Assignment {
+		t.Helper()
+		var a core.Assignment
+		req := asUser(postForm("/api/v1/classes/"+class.ID+"/assignments", values), uid)
+		if code != 200 {
+			s.call(req, code, nil)
+			return nil
+		}
+		s.call(req, code, &a)
+		return &a
+	}
+	a := assign("teacher", url.Values{"title": {"Draw a cat"}, "starterId": {starter.ID}, "due": {"2030-01-02T15:04:05Z"}}, 200)
+	if a.Due == nil || a.Due.Year() != 2030 {
+		t.Errorf("assignment = %+v", a)
+	}
+	assign("s1", url.Values{"title": {"x"}, "starterId": {starter.ID}}, 403)
+	assign("teacher", url.Values{"title": {"x"}, "starterId": {"999"}}, 400)
+	assign("teacher", url.Values{"title": {"x"}, "starterId": {starter.ID}, "due": {"tomorrow"}}, 400)
+
+	// The starter is private, yet each student gets a copy of their own,
+	// once.
+	var fork, again core.CodeFile
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/assignments/"+a.ID+"/accept", nil), "s1"), 200, &fork)
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/assignments/"+a.ID+"/accept", nil), "s1"), 200, &again)
+	if fork.AuthorId != "s1" || fork.ForkedFrom != starter.ID || fork.Visibility != core.VisibilityUnlisted || again.ID != fork.ID {
+		t.Errorf("accepted %+v, then %+v", fork, again)
+	}
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/assignments/"+a.ID+"/accept", nil), "teacher"), 400, nil)
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/assignments/"+a.ID+"/accept", nil), "s3"), 404, nil)
+
+	var assignments []core.Assignment
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/classes/"+class.ID+"/assignments", nil), "s1"), 200, &assignments)
+	if len(assignments) != 1 || assignments[0].ProjectID != fork.ID {
+		t.Errorf("assignments of s1 = %+v", assignments)
+	}
+
+	s.DB.Exec("UPDATE project SET u_time = ? WHERE id = ?", time.Now().Add(-time.Hour), fork.ID)
+	s.call(asUser(form("PUT", "/api/v1/projects/"+fork.ID, map[string]string{"name": "my cat"}, bundleV2), "s1"), 200, nil)
+	var submissions []core.Submission
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/assignments/"+a.ID+"/submissions", nil), "teacher"), 200, &submissions)
+	if len(submissions) != 2 || submissions[0].StudentId != "s1" || submissions[0].ProjectID != fork.ID ||
+		submissions[0].UTime == nil || time.Since(*submissions[0].UTime) > time.Minute ||
+		submissions[1].StudentId != "s2" || submissions[1].ProjectID != "" || submissions[1].UTime != nil {
+		t.Errorf("submissions = %+v", submissions)
+	}
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/assignments/"+a.ID+"/submissions", nil), "s1"), 403, nil)
+
+	// An accept of s2 racing with another: the fork of the loser is
+	// removed and both get the project of the winner.
+	var winner core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "winner", "uid": "s2"}, bundleV1), 200, &winner)
+	_, err := s.DB.Exec("CREATE TRIGGER race AFTER INSERT ON project WHEN NEW.author_id = 's2' AND NEW.forked_from IS NOT NULL BEGIN " +
+		"INSERT INTO submission (assignment_id, student_id, project_id, c_time) VALUES (" + a.ID + ", 's2', " + winner.ID + ", NEW.c_time); END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raced core.CodeFile
+	s.call(asUser(httptest.NewRequest("POST", "/api/v1/assignments/"+a.ID+"/accept", nil), "s2"), 200, &raced)
+	var n int
+	s.DB.QueryRow("SELECT COUNT(*) FROM project WHERE author_id = 's2'").Scan(&n)
+	if raced.ID != winner.ID || n != 1 {
+		t.Errorf("racing accept = %+v, s2 has %d projects, want 1", raced, n)
+	}
+
+	s.call(asUser(httptest.NewRequest("DELETE", "/api/v1/classes/"+class.ID+"/students/s2", nil), "s1"), 403, nil)
+	s.call(asUser(httptest.NewRequest("DELETE", "/api/v1/classes/"+class.ID+"/students/s2", nil), "s2"), 200, nil)
+	s.call(asUser(httptest.NewRequest("DELETE", "/api/v1/classes/"+class.ID+"/students/s1", nil), "teacher"), 200, nil)
+	var left core.Class
+	s.call(asUser(httptest.NewRequest("GET", "/api/v1/classes/"+class.ID, nil), "teacher"), 200, &left)
+	if len(left.Students) != 0 {
+		t.Errorf("students = %q", left.Students)
+	}
+}
+
+// sb3Project is the project.json of a Scratch project with a cat that
+// moves when the flag is clicked.
+const sb3Project = `{"targets": [
+	{"isStage": true, "name": "Stage", "currentCostume": 0, "blocks": {},
+		"costumes": [{"name": "backdrop1", "assetId": "b1", "md5ext": "b1.svg", "dataFormat": "svg", "rotationCenterX": 240, "rotationCenterY": 180}],
+		"sounds": []},
+	{"isStage": false, "name": "Cat 2", "currentCostume": 0, "layerOrder": 1, "visible": true,
+		"x": 10, "y": -20, "size": 50, "direction": 90,
+		"costumes": [{"name": "cat-a", "assetId": "c1", "md5ext": "c1.png", "dataFormat": "png", "bitmapResolution": 2, "rotationCenterX": 48, "rotationCenterY": 50}],
+		"sounds": [{"name": "Meow", "assetId": "s1", "md5ext": "s1.wav", "dataFormat": "wav", "rate": 48000, "sampleCount": 1000}],
+		"blocks": {
+			"a": {"opcode": "event_whenflagclicked", "next": "b", "topLevel": true, "x": 0, "y": 0, "inputs": {}, "fields": {}},
+			"b": {"opcode": "control_forever", "next": null, "inputs": {"SUBSTACK": [2, "c"]}, "fields": {}},
+			"c": {"opcode": "motion_movesteps", "next": "d", "inputs": {"STEPS": [1, [4, "10"]]}, "fields": {}},
+			"d": {"opcode": "control_if", "next": "g", "inputs": {"CONDITION": [2, "e"], "SUBSTACK": [2, "f"]}, "fields": {}},
+			"e": {"opcode": "sensing_touchingobject", "inputs": {"TOUCHINGOBJECTMENU": [1, "e2"]}, "fields": {}},
+			"e2": {"opcode": "sensing_touchingobjectmenu", "shadow": true, "inputs": {}, "fields": {"TOUCHINGOBJECTMENU": ["_edge_", null]}},
+			"f": {"opcode": "sound_play", "next": null, "inputs": {"SOUND_MENU": [1, "f2"]}, "fields": {}},
+			"f2": {"opcode": "sound_sounds_menu", "shadow": true, "inputs": {}, "fields": {"SOUND_MENU": ["Meow", null]}},
+			"g": {"opcode": "data_setvariableto", "next": null, "inputs": {}, "fields": {}}
+		}}
+]}`
+
+// zipFiles returns a zip archive of the given files.
+func zipFiles(files map[string]string) string {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, _ := zw.Create(name)
+		io.WriteString(w, data)
+	}
+	zw.Close()
+	return buf.String()
+}
+
+// sb3Form returns a request importing an sb3 archive with the given
+// files.
+func sb3Form(path string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "Cat Game.sb3")
+	io.WriteString(fw, zipFiles(files))
+	mw.Close()
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestImportSb3Routes(t *testing.T) {
+	s := newTestServer(t)
+	files := map[string]string{
+		"project.json": sb3Project,
+		"b1.svg":       "<svg/>",
+		"c1.png":       "\x89PNG",
+		"s1.wav":       "RIFF",
+	}
+	for _, path := range []string{"/project/import/sb3", "/api/v1/imports/sb3"} {
+		var res core.Sb3Import
+		s.call(asUser(sb3Form(path, files), "u1"), 200, &res)
+		if res.Project == nil || res.Project.ID == "" || res.Project.Name != "Cat Game" || res.Project.AuthorId != "u1" ||
+			!strings.HasPrefix(res.Project.Address, coretest.QiniuPath) {
+			t.Fatalf("POST %s: project = %+v", path, res.Project)
+		}
+		cat := res.Sprites["Cat_2"]
+		if len(res.Sprites) != 1 || cat == nil || !strings.HasPrefix(cat.Address.IndexJson, coretest.QiniuPath) || cat.Address.Assets["cat_a.png"] == "" {
+			t.Fatalf("POST %s: sprites = %+v", path, res.Sprites)
+		}
+		if meow := res.Sounds["Meow"]; len(res.Sounds) != 1 || meow == nil || meow.Address.Assets["Meow.wav"] == "" || meow.AssetType != "sound" {
+			t.Errorf("POST %s: sounds = %+v", path, res.Sounds)
+		}
+		// the sprites and sounds are assets of the importer
+		var asset core.AssetResponse
+		s.get("/api/v1/assets/"+cat.ID, 200, &asset)
+		if asset.AuthorId != "u1" || asset.AssetType != "sprite" || asset.Address.IndexJson != cat.Address.IndexJson {
+			t.Errorf("POST %s: sprite asset = %+v", path, asset)
+		}
+		want := []core.UnsupportedBlock{{Sprite: "Cat_2", Opcode: "data_setvariableto", Count: 1}}
+		if !reflect.DeepEqual(res.Unsupported, want) {
+			t.Errorf("POST %s: unsupported = %+v, want %+v", path, res.Unsupported, want)
+		}
+
+		w := s.do(httptest.NewRequest("GET", "/api/v1/projects/"+res.Project.ID+"/export", nil))
+		code := "-- Cat_2.spx --\nonStart => {\n\tforever => {\n\t\tstep 10\n\t\tif touching(Edge) {\n\t\t\tplay Meow\n\t\t}\n" +
+			"\t\t// TODO: data_setvariableto\n\t}\n}\n"
+		if !strings.Contains(w.Body.String(), code) || !strings.Contains(w.Body.String(), "\tCat_2 Cat_2\n\tMeow Sound\n") ||
+			!strings.Contains(w.Body.String(), "-- assets/sprites/Cat_2/index.json --") {
+			t.Errorf("POST %s: exported as %s", path, w.Body)
+		}
+	}
+
+	s.call(sb3Form("/api/v1/imports/sb3", files), 400, nil)
+	s.call(asUser(form("POST", "/api/v1/imports/sb3", nil, bundleV1), "u1"), 400, nil)
+	delete(files, "c1.png")
+	s.call(asUser(sb3Form("/api/v1/imports/sb3", files), "u1"), 400, nil)
+	delete(files, "project.json")
+	s.call(asUser(sb3Form("/api/v1/imports/sb3", files), "u1"), 400, nil)
+}
+
+// pngImage returns a PNG of a w by h image.
+func pngImage(w, h int) string {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)))
+	return buf.String()
+}
+
+func TestThumbnailRoutes(t *testing.T) {
+	s := newTestServer(t)
+	bundle := zipFiles(map[string]string{
+		"main.spx":          "onStart => {\n}\n",
+		"assets/index.json": `{"backdrops": [{"name": "sky", "path": "sky.png"}], "backdropIndex": 0}`,
+		"assets/sky.png":    pngImage(640, 480),
+	})
+	var saved core.CodeFile
+	s.call(form("POST", "/api/v1/projects", map[string]string{"name": "sky", "uid": "u1", "visibility": "public"}, bundle), 200, &saved)
+	if !strings.HasPrefix(saved.Thumbnail, coretest.QiniuPath) || !strings.HasSuffix(saved.Thumbnail, ".png") {
+		t.Fatalf("saved thumbnail = %q", saved.Thumbnail)
+	}
+	var got core.CodeFile
+	s.get("/api/v1/projects/"+saved.ID, 200, &got)
+	var fork core.CodeFile
+	s.call(postForm("/api/v1/projects/"+saved.ID+"/forks", url.Values{"uid": {"u2"}}), 200, &fork)
+	var gallery struct{ Data []core.GalleryProject }
+	s.get("/api/v1/gallery", 200, &gallery)
+	if got.Thumbnail != saved.Thumbnail || fork.Thumbnail != saved.Thumbnail || len(gallery.Data) != 1 || gallery.Data[0].Thumbnail != saved.Thumbnail {
+		t.Errorf("thumbnails: project %q, fork %q, gallery %+v, want %q", got.Thumbnail, fork.Thumbnail, gallery.Data, saved.Thumbnail)
+	}
+
+	var resaved core.CodeFile
+	s.call(form("PUT", "/api/v1/projects/"+saved.ID, map[string]string{"name": "sky", "uid": "u1"}, bundleV1), 200, &resaved)
+	var plain core.CodeFile
+	s.get("/api/v1/projects/"+saved.ID, 200, &plain)
+	if resaved.Thumbnail != "" || plain.Thumbnail != "" {
+		t.Errorf("thumbnail without images = %q, %q", resaved.Thumbnail, plain.Thumbnail)
+	}
+
+	// The sprites of an import are assets, with a thumbnail of their own.
+	var imported core.Sb3Import
+	s.call(asUser(sb3Form("/api/v1/imports/sb3", map[string]string{
+		"project.json": sb3Project,
+		"b1.svg":       `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 480 360"><rect width="480" height="360" fill="#00f"/></svg>`,
+		"c1.png":       pngImage(96, 100),
+		"s1.wav":       "RIFF",
+	}), "u1"), 200, &imported)
+	if imported.Project.Thumbnail == "" {
+		t.Errorf("sb3 thumbnail is empty")
+	}
+	if thumb := imported.Sprites["Cat_2"].ThumbnailURL; !strings.HasPrefix(thumb, coretest.QiniuPath) {
+		t.Errorf("imported sprite thumbnail = %q", thumb)
+	}
+
+	// An asset stored without a thumbnail gets one when it is fetched.
+	sprite := imported.Sprites["Cat_2"].Address
+	address, _ := json.Marshal(core.AssetAddress{
+		Assets:    map[string]string{"cat_a.png": strings.TrimPrefix(sprite.Assets["cat_a.png"], coretest.QiniuPath)},
+		IndexJson: strings.TrimPrefix(sprite.IndexJson, coretest.QiniuPath),
+	})
+	cat := s.AddAsset(t, &core.Asset{Name: "cat", AuthorId: "u1", Address: string(address), AssetType: "sprite", Status: 1})
+	var list struct{ Data []core.AssetResponse }
+	s.get("/api/v1/assets?type=sprite", 200, &list)
+	if len(list.Data) != 2 || list.Data[1].ID != cat || list.Data[1].ThumbnailURL != "" {
+		t.Errorf("assets before a fetch = %+v", list.Data)
+	}
+	var asset core.AssetResponse
+	s.get("/api/v1/assets/"+cat, 200, &asset)
+	s.get("/api/v1/assets?type=sprite", 200, &list)
+	if !strings.HasPrefix(asset.ThumbnailURL, coretest.QiniuPath) || len(list.Data) != 2 || list.Data[1].ThumbnailURL != asset.ThumbnailURL {
+		t.Errorf("asset thumbnail = %q, listed as %+v", asset.ThumbnailURL, list.Data)
+	}
+
+	// An image that can't be rendered is only tried once.
+	address, _ = json.Marshal(core.AssetAddress{
+		Assets: map[string]string{"bad.png": strings.TrimPrefix(sprite.IndexJson, coretest.QiniuPath)},
+	})
+	bad := s.AddAsset(t, &core.Asset{Name: "bad", AuthorId: "u1", Address: string(address), AssetType: "sprite", Status: 1})
+	for i := 0; i < 2; i++ {
+		var got core.AssetResponse
+		s.get("/api/v1/assets/"+bad, 200, &got)
+		var key string
+		s.DB.QueryRow("SELECT thumbnail FROM asset WHERE id = ?", bad).Scan(&key)
+		if got.ThumbnailURL != "" || key != "-" {
+			t.Errorf("broken asset thumbnail = %q, recorded as %q", got.ThumbnailURL, key)
+		}
+	}
+}
+
+// assetForm returns a multipart request uploading files as an asset.
+func assetForm(path string, fields map[string]string, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for name, data := range files {
+		fw, _ := mw.CreateFormFile("file", name)
+		io.WriteString(fw, data)
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// wavSound returns a 16-bit PCM WAV file of n silent mono samples.
+func wavSound(rate, n int) string {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+2*n))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, []uint32{16, 1<<16 | 1, uint32(rate), uint32(2 * rate), 16<<16 | 2})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(2*n))
+	buf.Write(make([]byte, 2*n))
+	return buf.String()
+}
+
+func TestCreateAssetRoutes(t *testing.T) {
+	s := newTestServer(t)
+	meow := map[string]string{"name": "meow", "assetType": "sound", "category": "animals", "isPublic": "1"}
+	var sound core.AssetResponse
+	s.call(asUser(assetForm("/api/v1/assets", meow, map[string]string{"meow.wav": wavSound(22050, 11025)}), "u1"), 200, &sound)
+	if sound.ID == "" || sound.AuthorId != "u1" || sound.IsPublic != 1 || sound.Duration != 0.5 || sound.SampleRate != 22050 || sound.Channels != 1 ||
+		!strings.HasPrefix(sound.Address.Assets["meow.wav"], coretest.QiniuPath) {
+		t.Fatalf("sound = %+v", sound)
+	}
+	var got core.AssetResponse
+	s.get("/api/v1/assets/"+sound.ID, 200, &got)
+	if got.Duration != 0.5 || got.SampleRate != 22050 || got.Channels != 1 {
+		t.Errorf("fetched sound = %+v", got)
+	}
+	var size int64
+	s.DB.QueryRow("SELECT size FROM asset WHERE id = ?", sound.ID).Scan(&size)
+	if size != 44+2*11025 {
+		t.Errorf("size = %d", size)
+	}
+
+	s.Conf.SoundSampleRate = 44100
+	var resampled core.AssetResponse
+	s.call(asUser(assetForm("/asset", meow, map[string]string{"meow.wav": wavSound(22050, 11025)}), "u1"), 200, &resampled)
+	if resampled.Duration != 0.5 || resampled.SampleRate != 44100 {
+		t.Errorf("resampled sound = %+v", resampled)
+	}
+
+	for _, files := range []map[string]string{
+		{"meow.wav": "RIFF\x00\x00\x00\x00WAVE"},
+		{"meow.mp3": "not an mp3"},
+		{"meow.txt": "meow"},
+		{"a.wav": wavSound(8000, 1), "b.wav": wavSound(8000, 1)},
+		{},
+	} {
+		s.call(asUser(assetForm("/api/v1/assets", meow, files), "u1"), 400, nil)
+	}
+	s.call(assetForm("/api/v1/assets", meow, map[string]string{"meow.wav": wavSound(8000, 1)}), 400, nil)
+
+	var sprite core.AssetResponse
+	s.call(asUser(assetForm("/api/v1/assets", map[string]string{"name": "cat", "assetType": "sprite"}, map[string]string{
+		"index.json": `{"costumes": [{"name": "cat", "path": "cat.png"}]}`,
+		"cat.png":    pngImage(64, 64),
+	}), "u1"), 200, &sprite)
+	if !strings.HasPrefix(sprite.Address.IndexJson, coretest.QiniuPath) || len(sprite.Address.Assets) != 1 || sprite.ThumbnailURL == "" || sprite.Duration != 0 {
+		t.Errorf("sprite = %+v", sprite)
+	}
+}
+
+func TestSpriteAssetRoutes(t *testing.T) {
+	s := newTestServer(t)
+	cat := map[string]string{"name": "cat", "assetType": "sprite"}
+	files := map[string]string{
+		"index.json": `{"costumes": [
+			{"name": "cat-a", "path": "a.png", "x": 32, "y": 40, "bitmapResolution": 2},
+			{"name": "cat-b", "path": "b.svg", "x": 15, "y": 10},
+			{"name": "cat-c", "path": "a.png", "x": 0, "y": 0, "bitmapResolution": 2}
+		], "costumeIndex": 1}`,
+		"a.png": pngImage(64, 80),
+		"b.svg": `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 30 20"><rect width="30" height="20" fill="#0f0"/></svg>`,
+	}
+	var sprite core.AssetResponse
+	s.call(asUser(assetForm("/api/v1/assets", cat, files), "u1"), 200, &sprite)
+	atlas := sprite.Address.Atlas
+	if atlas == nil || !strings.HasPrefix(atlas.Image, coretest.QiniuPath) || len(atlas.Costumes) != 3 {
+		t.Fatalf("atlas = %+v", atlas)
+	}
+	a, b, c := atlas.Costumes[0], atlas.Costumes[1], atlas.Costumes[2]
+	if a.Name != "cat-a" || a.Width != 64 || a.Height != 80 || a.RotationCenterX != 32 || a.BitmapResolution != 2 ||
+		b.Name != "cat-b" || b.Width != 30 || b.Height != 20 || b.BitmapResolution != 1 ||
+		c.X != a.X || c.Y != a.Y {
+		t.Errorf("costumes = %+v", atlas.Costumes)
+	}
+	ra := image.Rect(a.X, a.Y, a.X+a.Width, a.Y+a.Height)
+	rb := image.Rect(b.X, b.Y, b.X+b.Width, b.Y+b.Height)
+	bounds := image.Rect(0, 0, atlas.Width, atlas.Height)
+	if ra.Overlaps(rb) || !ra.In(bounds) || !rb.In(bounds) {
+		t.Errorf("costumes %v and %v in a %v atlas", ra, rb, bounds)
+	}
+	var got core.AssetResponse
+	s.get("/api/v1/assets/"+sprite.ID, 200, &got)
+	if got.Address.Atlas == nil || got.Address.Atlas.Image != atlas.Image || len(got.Address.Atlas.Costumes) != 3 {
+		t.Errorf("fetched atlas = %+v", got.Address.Atlas)
+	}
+
+	for _, index := range []string{
+		`{"costumes": []}`,
+		`{"costumes": [{"name": "cat-a", "path": "missing.png"}]}`,
+		`{"costumes": [{"name": "cat-a", "path": "a.png"}, {"name": "cat-a", "path": "b.svg"}]}`,
+		`{"costumes": [{"name": "cat-a", "path": "a.png", "x": 65}]}`,
+		`{"costumes": [{"name": "cat-a", "path": "a.png"}], "costumeIndex": 1}`,
+		`{"costumes": [{"name": "cat-a", "path": "bad.png"}]}`,
+		`not json`,
+	} {
+		bad := map[string]string{"index.json": index, "a.png": files["a.png"], "b.svg": files["b.svg"], "bad.png": "not a png"}
+		s.call(asUser(assetForm("/api/v1/assets", cat, bad), "u1"), 400, nil)
+	}
+	s.call(asUser(assetForm("/api/v1/assets", cat, map[string]string{"a.png": files["a.png"]}), "u1"), 400, nil)
+
+	// Each costume is small enough, but not all of them together.
+	huge := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 3000 3000"><rect width="3000" height="3000"/></svg>`
+	s.call(asUser(assetForm("/api/v1/assets", cat, map[string]string{
+		"index.json": `{"costumes": [{"name": "a", "path": "a.svg"}, {"name": "b", "path": "b.svg"}]}`,
+		"a.svg":      huge,
+		"b.svg":      huge,
+	}), "u1"), 413, nil)
+}
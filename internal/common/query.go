@@ -1,12 +1,14 @@
 package common
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 type FilterCondition struct {
@@ -15,19 +17,27 @@ type FilterCondition struct {
 	Value     interface{} // 值
 }
 
+// MaxPageSize 分页查询每页的最大条数
+const MaxPageSize = 100
+
+// ErrInvalidPage 页码或每页条数不合法
+var ErrInvalidPage = errors.New("invalid page")
+
 type Pagination[T any] struct {
 	TotalCount int
 	TotalPage  int
 	Data       []T
 }
 
-// QueryByPage 通用的 分页查询
-func QueryByPage[T any](db *sql.DB, pageIndexParam string, pageSizeParam string, filters []FilterCondition) (*Pagination[T], error) {
-	pageIndex, err := strconv.Atoi(pageIndexParam)
-	if err != nil {
-		return nil, err
-	}
-	pageSize, err := strconv.Atoi(pageSizeParam)
+// Tabler 由表名与类型名不一致的结构体实现，返回其对应的表名
+type Tabler interface {
+	TableName() string
+}
+
+// QueryByPage 通用的 分页查询，orderBy 为 ORDER BY 子句的内容（如 "c_time DESC"），
+// 为空时不排序。orderBy 会直接拼入 SQL，不能来自用户输入
+func QueryByPage[T any](ctx context.Context, db *sql.DB, pageIndexParam string, pageSizeParam string, filters []FilterCondition, orderBy string) (*Pagination[T], error) {
+	pageIndex, pageSize, err := ParsePage(pageIndexParam, pageSizeParam)
 	if err != nil {
 		return nil, err
 	}
@@ -38,16 +48,20 @@ func QueryByPage[T any](db *sql.DB, pageIndexParam string, pageSizeParam string,
 	var totalCount int
 	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM %s%s`, tableName, whereClause)
 	argsForCount := append([]interface{}{}, args...)
-	err = db.QueryRow(countQuery, argsForCount...).Scan(&totalCount)
+	err = db.QueryRowContext(ctx, countQuery, argsForCount...).Scan(&totalCount)
 	if err != nil {
 		return nil, err
 	}
 	totalPage := (totalCount + pageSize - 1) / pageSize
 
 	offset := (pageIndex - 1) * pageSize
-	query := fmt.Sprintf("SELECT * FROM %s%s LIMIT ?, ?", tableName, whereClause)
+	orderClause := ""
+	if orderBy != "" {
+		orderClause = " ORDER BY " + orderBy
+	}
+	query := fmt.Sprintf("SELECT * FROM %s%s%s LIMIT ?, ?", tableName, whereClause, orderClause)
 	argsForQuery := append(args, offset, pageSize) // 添加 LIMIT 参数
-	rows, err := db.Query(query, argsForQuery...)
+	rows, err := db.QueryContext(ctx, query, argsForQuery...)
 	if err != nil {
 		return nil, err
 	}
@@ -68,10 +82,26 @@ func QueryByPage[T any](db *sql.DB, pageIndexParam string, pageSizeParam string,
 	}, nil
 }
 
+// ParsePage 解析并校验页码与每页条数
+func ParsePage(pageIndexParam string, pageSizeParam string) (pageIndex int, pageSize int, err error) {
+	pageIndex, err = strconv.Atoi(pageIndexParam)
+	if err != nil {
+		return 0, 0, err
+	}
+	pageSize, err = strconv.Atoi(pageSizeParam)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pageIndex < 1 || pageSize < 1 || pageSize > MaxPageSize {
+		return 0, 0, fmt.Errorf("%w: page %d of size %d", ErrInvalidPage, pageIndex, pageSize)
+	}
+	return pageIndex, pageSize, nil
+}
+
 // QueryById 通用的 SELECT 查询，唯一查询条件为id
-func QueryById[T any](db *sql.DB, id string) (*T, error) {
+func QueryById[T any](ctx context.Context, db *sql.DB, id string) (*T, error) {
 	wheres := []FilterCondition{{Column: "id", Operation: "=", Value: id}}
-	results, err := QuerySelect[T](db, wheres)
+	results, err := QuerySelect[T](ctx, db, wheres)
 	if len(results) == 0 {
 		return nil, err
 	}
@@ -79,13 +109,13 @@ func QueryById[T any](db *sql.DB, id string) (*T, error) {
 }
 
 // QuerySelect 通用的 SELECT 查询，可以自定义查询条件
-func QuerySelect[T any](db *sql.DB, filters []FilterCondition) ([]T, error) {
+func QuerySelect[T any](ctx context.Context, db *sql.DB, filters []FilterCondition) ([]T, error) {
 	tableName := getTableName[T]()
 	scan := tScan[T]()
 	whereClause, args := buildWhereClause(filters)
 
 	query := fmt.Sprintf("SELECT * FROM %s%s", tableName, whereClause)
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +133,8 @@ func QuerySelect[T any](db *sql.DB, filters []FilterCondition) ([]T, error) {
 	return results, nil
 }
 
-// tScan 创建并返回一个适用于 任意结构体 的Scan
+// tScan 创建并返回一个适用于 任意结构体 的Scan，按列名匹配字段（见 columnName），
+// 没有对应字段的列会被忽略
 func tScan[T any]() func(rows *sql.Rows) (T, error) {
 	return func(rows *sql.Rows) (T, error) {
 		var item T
@@ -117,17 +148,21 @@ func tScan[T any]() func(rows *sql.Rows) (T, error) {
 			return item, err
 		}
 
-		if len(columns) != itemVal.NumField() {
-			return item, errors.New("number of columns does not match struct fields")
+		fields := make(map[string]int)
+		itemType := itemVal.Type()
+		for i := 0; i < itemType.NumField(); i++ {
+			if field := itemType.Field(i); field.IsExported() {
+				fields[columnName(field)] = i
+			}
 		}
 
 		columnPointers := make([]interface{}, len(columns))
-		for i := 0; i < len(columns); i++ {
-			field := itemVal.Field(i)
-			if !field.CanSet() {
-				return item, errors.New("cannot set struct field " + columns[i])
+		for i, column := range columns {
+			if j, ok := fields[column]; ok {
+				columnPointers[i] = itemVal.Field(j).Addr().Interface()
+			} else {
+				columnPointers[i] = new(interface{})
 			}
-			columnPointers[i] = field.Addr().Interface()
 		}
 
 		if err := rows.Scan(columnPointers...); err != nil {
@@ -138,6 +173,26 @@ func tScan[T any]() func(rows *sql.Rows) (T, error) {
 	}
 }
 
+// columnName 返回字段对应的列名：优先使用 db tag，否则将字段名转为蛇形命名，
+// 如 AuthorId -> author_id，CTime -> c_time
+func columnName(field reflect.StructField) string {
+	if name := field.Tag.Get("db"); name != "" {
+		return name
+	}
+	name := []rune(field.Name)
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(name[i-1]) || i+1 < len(name) && unicode.IsLower(name[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 // buildWhereClause 根据 FilterCondition 构建 WHERE 子句
 func buildWhereClause(conditions []FilterCondition) (string, []interface{}) {
 	var whereClauses []string
@@ -160,7 +215,11 @@ func buildWhereClause(conditions []FilterCondition) (string, []interface{}) {
 	return whereClause, args
 }
 
-// getTableName 基于反射获取表名
+// getTableName 基于反射获取表名，T 实现 Tabler 时使用其 TableName
 func getTableName[T any]() string {
+	var item T
+	if t, ok := any(item).(Tabler); ok {
+		return t.TableName()
+	}
 	return strings.ToLower(reflect.TypeOf((*T)(nil)).Elem().Name())
 }
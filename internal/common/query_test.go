@@ -0,0 +1,64 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildWhereClause(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []FilterCondition
+		where      string
+		args       []interface{}
+	}{
+		{
+			name:  "none",
+			where: " WHERE status != ?",
+			args:  []interface{}{0},
+		},
+		{
+			name:       "one",
+			conditions: []FilterCondition{{Column: "id", Operation: "=", Value: "7"}},
+			where:      " WHERE id = ? AND status != ?",
+			args:       []interface{}{"7", 0},
+		},
+		{
+			name: "several",
+			conditions: []FilterCondition{
+				{Column: "asset_type", Operation: "=", Value: "sprite"},
+				{Column: "c_time", Operation: "<", Value: 100},
+			},
+			where: " WHERE asset_type = ? AND c_time < ? AND status != ?",
+			args:  []interface{}{"sprite", 100, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := buildWhereClause(tt.conditions)
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	type row struct {
+		ID        string
+		AuthorId  string
+		CTime     string
+		AssetType string
+		Tagged    string `db:"other"`
+	}
+	want := []string{"id", "author_id", "c_time", "asset_type", "other"}
+	typ := reflect.TypeOf(row{})
+	for i, w := range want {
+		if got := columnName(typ.Field(i)); got != w {
+			t.Errorf("columnName(%s) = %q, want %q", typ.Field(i).Name, got, w)
+		}
+	}
+}
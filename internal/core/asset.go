@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AssetSound is the AssetType of sounds, whose file is checked and
+// described when it is uploaded.
+const AssetSound = "sound"
+
+// CreateAsset uploads the files of form, sent as "file", as asset a of
+// user a.AuthorId. A file named index.json becomes the index of a
+// sprite; it is checked, see checkSprite, and the costumes it lists are
+// packed into an atlas. A sound must be a single WAV, MP3 or OGG file:
+// its duration, sample rate and channel count are read from its headers,
+// and one that can't be read is rejected. With Config.SoundSampleRate
+// set, 16-bit PCM WAV sounds are resampled to it first.
+func (p *Project) CreateAsset(ctx context.Context, a *Asset, form *multipart.Form) (*AssetResponse, error) {
+	switch {
+	case a.AuthorId == "":
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	case a.Name == "":
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidParam)
+	case a.AssetType == "":
+		return nil, fmt.Errorf("%w: assetType is required", ErrInvalidParam)
+	case form == nil || len(form.File["file"]) == 0:
+		return nil, http.ErrMissingFile
+	}
+	headers := form.File["file"]
+	if len(headers) > p.limits.numFiles {
+		return nil, &LimitError{What: "number of files", Value: len(headers), Limit: p.limits.numFiles}
+	}
+	fs := new(fileSet)
+	for _, h := range headers {
+		if err := checkFileName(h.Filename, p.limits); err != nil {
+			return nil, err
+		}
+		if fs.Contains(h.Filename) {
+			return nil, &FileError{Name: h.Filename, Reason: "duplicate file name"}
+		}
+		data, err := readFormFile(h, p.limits)
+		if err != nil {
+			return nil, err
+		}
+		fs.AddFile(h.Filename, data)
+	}
+	var sprite *spriteFiles
+	switch a.AssetType {
+	case AssetSprite:
+		var err error
+		if sprite, err = checkSprite(fs); err != nil {
+			return nil, err
+		}
+	case AssetSound:
+		if err := p.describeSound(a, fs); err != nil {
+			return nil, err
+		}
+	}
+	a.Size = 0
+	for _, name := range fs.files {
+		a.Size += int64(len(fs.Data(name)))
+	}
+	if err := p.checkQuota(ctx, &CodeFile{AuthorId: a.AuthorId}, a.Size); err != nil {
+		return nil, err
+	}
+	return p.storeAsset(ctx, a, fs, sprite)
+}
+
+// storeAsset uploads fs, the files of asset a, under Config.SpiritPath
+// and records a, of a.Size bytes. The costumes of sprite, if not nil,
+// are packed into an atlas.
+func (p *Project) storeAsset(ctx context.Context, a *Asset, fs *fileSet, sprite *spriteFiles) (*AssetResponse, error) {
+	address := AssetAddress{Assets: make(map[string]string)}
+	for _, name := range fs.files {
+		key, err := UploadReader(ctx, p, p.conf.SpiritPath, name, bytes.NewReader(fs.Data(name)))
+		if err != nil {
+			return nil, err
+		}
+		if name == "index.json" {
+			address.IndexJson = key
+		} else {
+			address.Assets[name] = key
+		}
+	}
+	if sprite != nil {
+		address.Atlas = p.spriteAtlas(ctx, sprite)
+	}
+	b, err := json.Marshal(address)
+	if err != nil {
+		return nil, err
+	}
+	a.Address, a.Status = string(b), 1
+	a.CTime = time.Now()
+	a.UTime = a.CTime
+	res, err := p.db.ExecContext(ctx, "INSERT INTO asset (name, author_id, category, is_public, address, asset_type, status, size, "+
+		"duration, sample_rate, channels, c_time, u_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		a.Name, a.AuthorId, a.Category, a.IsPublic, a.Address, a.AssetType, a.Status, a.Size,
+		a.Duration, a.SampleRate, a.Channels, a.CTime, a.UTime)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	a.ID = strconv.FormatInt(id, 10)
+	p.assetThumbnail(ctx, a)
+	return p.assetResponse(a)
+}
+
+// describeSound checks that fs is a single sound file, resampling it if
+// configured, and records its info in a.
+func (p *Project) describeSound(a *Asset, fs *fileSet) error {
+	if fs.Num() != 1 || !isSound(fs.files[0]) {
+		return fmt.Errorf("%w: a sound is a single wav, mp3 or ogg file", ErrInvalidParam)
+	}
+	name := fs.files[0]
+	if rate := p.conf.SoundSampleRate; rate != 0 && strings.ToLower(path.Ext(name)) == ".wav" {
+		data, err := resampleWAV(fs.Data(name), rate, p.limits.size)
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			return err
+		}
+		if err != nil {
+			return &FileError{Name: name, Reason: err.Error()}
+		}
+		fs.AddFile(name, data)
+	}
+	info, err := parseSound(name, fs.Data(name))
+	if err != nil {
+		return err
+	}
+	a.Duration, a.SampleRate, a.Channels = info.Duration, info.SampleRate, info.Channels
+	return nil
+}
+
+// readFormFile returns the content of an uploaded file, see readUpload.
+func readFormFile(h *multipart.FileHeader, lim fileLimits) ([]byte, error) {
+	f, err := h.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readUpload(f, lim)
+}
+
+// readUpload reads an uploaded file, failing with a *LimitError as soon
+// as it turns out larger than a project may be once unpacked.
+func readUpload(r io.Reader, lim fileLimits) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(lim.size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > lim.size {
+		return nil, &LimitError{What: "upload size", Value: len(data), Limit: lim.size}
+	}
+	return data, nil
+}
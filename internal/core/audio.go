@@ -0,0 +1,382 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+)
+
+// soundInfo is what is recorded about a sound asset, read from the
+// headers of its file so the editor can show it without downloading it.
+type soundInfo struct {
+	Duration   float64 // seconds
+	SampleRate int
+	Channels   int
+}
+
+// soundParsers are the formats sound assets may be uploaded in, by
+// extension.
+var soundParsers = map[string]func([]byte) (*soundInfo, error){
+	".wav": parseWAV,
+	".mp3": parseMP3,
+	".ogg": parseOGG,
+}
+
+// isSound reports whether file name has the extension of a sound.
+func isSound(name string) bool {
+	return soundParsers[strings.ToLower(path.Ext(name))] != nil
+}
+
+// parseSound reads the info of sound file name. A file that is truncated
+// or otherwise corrupt is reported as a *FileError.
+func parseSound(name string, data []byte) (*soundInfo, error) {
+	parse := soundParsers[strings.ToLower(path.Ext(name))]
+	if parse == nil {
+		return nil, &FileError{Name: name, Reason: "unsupported sound format"}
+	}
+	info, err := parse(data)
+	if err != nil {
+		return nil, &FileError{Name: name, Reason: err.Error()}
+	}
+	return info, nil
+}
+
+// wavFormat is the fmt chunk of a WAV file.
+type wavFormat struct {
+	PCM           bool // integer samples, possibly in a WAVE_FORMAT_EXTENSIBLE
+	Channels      int
+	SampleRate    int
+	ByteRate      int
+	BlockAlign    int
+	BitsPerSample int
+}
+
+// minWAVSampleRate is the lowest sample rate of a WAV file accepted.
+const minWAVSampleRate = 8000
+
+// readWAV returns the format and the samples of a RIFF WAVE file.
+// Chunks other than fmt and data are skipped. The fmt chunk must be
+// consistent: PCM frames must hold a sample of each channel, and the
+// sample rate be at least minWAVSampleRate.
+func readWAV(data []byte) (*wavFormat, []byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, nil, errors.New("wav: not a RIFF WAVE file")
+	}
+	var format *wavFormat
+	for off := 12; off+8 <= len(data); {
+		id, size := string(data[off:off+4]), int(binary.LittleEndian.Uint32(data[off+4:]))
+		off += 8
+		if size > len(data)-off {
+			return nil, nil, fmt.Errorf("wav: %q chunk is truncated", id)
+		}
+		chunk := data[off : off+size]
+		off += size + size&1 // chunks are padded to an even size
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, nil, errors.New("wav: fmt chunk is too short")
+			}
+			tag := binary.LittleEndian.Uint16(chunk)
+			if tag == 0xfffe && size >= 26 { // WAVE_FORMAT_EXTENSIBLE, the tag is the start of the sub format
+				tag = binary.LittleEndian.Uint16(chunk[24:])
+			}
+			format = &wavFormat{
+				PCM:           tag == 1,
+				Channels:      int(binary.LittleEndian.Uint16(chunk[2:])),
+				SampleRate:    int(binary.LittleEndian.Uint32(chunk[4:])),
+				ByteRate:      int(binary.LittleEndian.Uint32(chunk[8:])),
+				BlockAlign:    int(binary.LittleEndian.Uint16(chunk[12:])),
+				BitsPerSample: int(binary.LittleEndian.Uint16(chunk[14:])),
+			}
+			if format.Channels == 0 || format.ByteRate == 0 || format.BlockAlign == 0 {
+				return nil, nil, errors.New("wav: invalid fmt chunk")
+			}
+			if format.PCM && format.BlockAlign != format.Channels*format.BitsPerSample/8 {
+				return nil, nil, fmt.Errorf("wav: block align %d doesn't match %d channels of %d bits",
+					format.BlockAlign, format.Channels, format.BitsPerSample)
+			}
+			if format.SampleRate < minWAVSampleRate {
+				return nil, nil, fmt.Errorf("wav: sample rate %d Hz is below %d Hz", format.SampleRate, minWAVSampleRate)
+			}
+		case "data":
+			if format == nil {
+				return nil, nil, errors.New("wav: data chunk before fmt chunk")
+			}
+			return format, chunk, nil
+		}
+	}
+	if format == nil {
+		return nil, nil, errors.New("wav: no fmt chunk")
+	}
+	return nil, nil, errors.New("wav: no data chunk")
+}
+
+func parseWAV(data []byte) (*soundInfo, error) {
+	format, samples, err := readWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	return &soundInfo{
+		Duration:   float64(len(samples)) / float64(format.ByteRate),
+		SampleRate: format.SampleRate,
+		Channels:   format.Channels,
+	}, nil
+}
+
+// resampleWAV converts a 16-bit PCM WAV file to rate samples per second
+// by linear interpolation and writes it with a canonical header. Other
+// WAV files, and those already at rate, are returned as they are. A
+// result over max bytes is a *LimitError.
+func resampleWAV(data []byte, rate, max int) ([]byte, error) {
+	format, samples, err := readWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if !format.PCM || format.BitsPerSample != 16 || format.SampleRate == rate {
+		return data, nil
+	}
+	channels := format.Channels
+	frames := len(samples) / format.BlockAlign
+	sample := func(frame, channel int) float64 {
+		i := frame*format.BlockAlign + channel*2
+		return float64(int16(binary.LittleEndian.Uint16(samples[i:])))
+	}
+	n := int64(frames) * int64(rate) / int64(format.SampleRate)
+	if size := 44 + n*int64(channels)*2; size > int64(max) {
+		return nil, &LimitError{What: "resampled size", Value: int(min(size, math.MaxInt32)), Limit: max}
+	}
+	out := make([]byte, 44+int(n)*channels*2)
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	copy(out[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[20:], 1)
+	binary.LittleEndian.PutUint16(out[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(out[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(out[28:], uint32(rate*channels*2))
+	binary.LittleEndian.PutUint16(out[32:], uint16(channels*2))
+	binary.LittleEndian.PutUint16(out[34:], 16)
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(len(out)-44))
+	step := float64(format.SampleRate) / float64(rate)
+	for i := 0; i < int(n); i++ {
+		pos := float64(i) * step
+		j := int(pos)
+		frac := pos - float64(j)
+		k := min(j+1, frames-1)
+		for c := 0; c < channels; c++ {
+			a, b := sample(j, c), sample(k, c)
+			v := int16(math.Round(a + (b-a)*frac))
+			binary.LittleEndian.PutUint16(out[44+(i*channels+c)*2:], uint16(v))
+		}
+	}
+	return out, nil
+}
+
+// mp3Frame is the header of an MPEG audio frame.
+type mp3Frame struct {
+	Size       int // bytes, header included
+	Samples    int // per channel
+	SampleRate int
+	Channels   int
+	SideInfo   int // bytes of side info after the header, for layer III
+}
+
+// Bit rates in kbit/s by MPEG version (1 or 2, which 2.5 shares), layer
+// and index.
+var mp3BitRates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// Sample rates of MPEG 1, 2 and 2.5 by index.
+var mp3SampleRates = [3][3]int{{44100, 48000, 32000}, {22050, 24000, 16000}, {11025, 12000, 8000}}
+
+// readMP3Frame decodes the frame header at the start of b. Free format
+// frames, whose size can't be known from the header, are not accepted.
+func readMP3Frame(b []byte) (*mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return nil, false
+	}
+	version := [4]int{2, -1, 1, 0}[b[1]>>3&3] // index into mp3SampleRates
+	layer := 4 - int(b[1]>>1&3)               // 1, 2 or 3; 4 is reserved
+	bitRate, rate := int(b[2]>>4), int(b[2]>>2&3)
+	if version < 0 || layer == 4 || bitRate == 0 || bitRate == 15 || rate == 3 {
+		return nil, false
+	}
+	f := &mp3Frame{SampleRate: mp3SampleRates[version][rate], Channels: 2}
+	if b[3]>>6 == 3 {
+		f.Channels = 1
+	}
+	padding := int(b[2] >> 1 & 1)
+	bps := mp3BitRates[min(version, 1)][layer-1][bitRate] * 1000
+	switch {
+	case layer == 1:
+		f.Samples = 384
+		f.Size = (12*bps/f.SampleRate + padding) * 4
+	case layer == 2 || version == 0:
+		f.Samples = 1152
+		f.Size = 144*bps/f.SampleRate + padding
+	default:
+		f.Samples = 576
+		f.Size = 72*bps/f.SampleRate + padding
+	}
+	if layer == 3 {
+		f.SideInfo = [2][2]int{{32, 17}, {17, 9}}[min(version, 1)][2-f.Channels]
+	}
+	return f, true
+}
+
+// parseMP3 adds up the frames of an MP3 file. Leading ID3v2 tags are
+// skipped, and so is anything after the last frame, such as an ID3v1
+// tag. A Xing or Info frame holds no audio and isn't counted.
+func parseMP3(data []byte) (*soundInfo, error) {
+	for len(data) >= 10 && string(data[:3]) == "ID3" {
+		size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+		size += 10
+		if data[5]&0x10 != 0 { // footer
+			size += 10
+		}
+		if size > len(data) {
+			return nil, errors.New("mp3: ID3 tag is truncated")
+		}
+		data = data[size:]
+	}
+	// Find the first frame that is followed by another one, or ends the
+	// file, so a stray sync word in junk isn't taken for one.
+	start := -1
+	for i := 0; i+4 <= len(data); i++ {
+		f, ok := readMP3Frame(data[i:])
+		if !ok || i+f.Size > len(data) {
+			continue
+		}
+		if _, ok := readMP3Frame(data[i+f.Size:]); ok || i+f.Size == len(data) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, errors.New("mp3: no audio frames")
+	}
+	var info *soundInfo
+	samples := 0
+	for off := start; ; {
+		f, ok := readMP3Frame(data[off:])
+		if !ok || off+f.Size > len(data) {
+			break
+		}
+		frame := data[off : off+f.Size]
+		off += f.Size
+		if info == nil {
+			if tag := frame[min(4+f.SideInfo, len(frame)):]; f.SideInfo > 0 &&
+				(bytes.HasPrefix(tag, []byte("Xing")) || bytes.HasPrefix(tag, []byte("Info"))) {
+				continue
+			}
+			info = &soundInfo{SampleRate: f.SampleRate, Channels: f.Channels}
+		}
+		samples += f.Samples
+	}
+	if info == nil {
+		return nil, errors.New("mp3: no audio frames")
+	}
+	info.Duration = float64(samples) / float64(info.SampleRate)
+	return info, nil
+}
+
+// oggCRC is the CRC-32 of Ogg pages: polynomial 0x04c11db7, not
+// reflected, with no initial or final XOR.
+var oggCRC = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return
+}()
+
+// parseOGG walks the pages of an Ogg file, checking their CRC, and reads
+// the first logical stream, which must be Vorbis or Opus. The duration
+// is the granule position of the last page of the stream.
+func parseOGG(data []byte) (*soundInfo, error) {
+	var info *soundInfo
+	var serial uint32
+	var granule int64
+	preSkip := 0
+	for off := 0; off < len(data); {
+		page := data[off:]
+		if len(page) < 27 || string(page[:4]) != "OggS" || page[4] != 0 {
+			return nil, fmt.Errorf("ogg: no page at offset %d", off)
+		}
+		segments := int(page[26])
+		if len(page) < 27+segments {
+			return nil, errors.New("ogg: page is truncated")
+		}
+		size := 27 + segments
+		for _, n := range page[27 : 27+segments] {
+			size += int(n)
+		}
+		if len(page) < size {
+			return nil, errors.New("ogg: page is truncated")
+		}
+		page = page[:size]
+		off += size
+		var crc uint32
+		for i, b := range page {
+			if i >= 22 && i < 26 {
+				b = 0
+			}
+			crc = crc<<8 ^ oggCRC[byte(crc>>24)^b]
+		}
+		if crc != binary.LittleEndian.Uint32(page[22:]) {
+			return nil, errors.New("ogg: page checksum mismatch")
+		}
+		body := page[27+segments:]
+		if info == nil {
+			serial = binary.LittleEndian.Uint32(page[14:])
+			switch {
+			case len(body) >= 16 && string(body[:7]) == "\x01vorbis":
+				info = &soundInfo{
+					Channels:   int(body[11]),
+					SampleRate: int(binary.LittleEndian.Uint32(body[12:])),
+				}
+			case len(body) >= 12 && string(body[:8]) == "OpusHead":
+				// Opus always decodes at 48 kHz; the input rate in the
+				// header is informational.
+				info = &soundInfo{Channels: int(body[9]), SampleRate: 48000}
+				preSkip = int(binary.LittleEndian.Uint16(body[10:]))
+			default:
+				return nil, errors.New("ogg: stream is neither Vorbis nor Opus")
+			}
+			if info.Channels == 0 || info.SampleRate == 0 {
+				return nil, errors.New("ogg: invalid stream header")
+			}
+			continue
+		}
+		if g := int64(binary.LittleEndian.Uint64(page[6:])); binary.LittleEndian.Uint32(page[14:]) == serial && g > 0 {
+			granule = g
+		}
+	}
+	if info == nil {
+		return nil, errors.New("ogg: no pages")
+	}
+	info.Duration = float64(max(granule-int64(preSkip), 0)) / float64(info.SampleRate)
+	return info, nil
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+// encodeWAV returns a 16-bit PCM WAV file of the given samples, with a
+// LIST chunk before the data as many editors write.
+func encodeWAV(rate, channels int, samples ...int16) []byte {
+	var buf bytes.Buffer
+	le := func(v any) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	le(uint32(4 + 8 + 16 + 8 + 4 + 8 + 2*len(samples)))
+	buf.WriteString("WAVEfmt ")
+	le(uint32(16))
+	le([]uint16{1, uint16(channels)})
+	le([]uint32{uint32(rate), uint32(rate * channels * 2)})
+	le([]uint16{uint16(channels * 2), 16})
+	buf.WriteString("LIST")
+	le(uint32(4))
+	buf.WriteString("INFO")
+	buf.WriteString("data")
+	le(uint32(2 * len(samples)))
+	le(samples)
+	return buf.Bytes()
+}
+
+// mp3Frames returns n MPEG 1 layer III frames at 128 kbit/s and 44.1 kHz,
+// 417 bytes each.
+func mp3Frames(n int, mono bool) []byte {
+	header := []byte{0xff, 0xfb, 0x90, 0x44}
+	if mono {
+		header[3] = 0xc4
+	}
+	frame := make([]byte, 417)
+	copy(frame, header)
+	return bytes.Repeat(frame, n)
+}
+
+// oggPage returns an Ogg page of a single packet.
+func oggPage(serial uint32, granule int64, packet []byte) []byte {
+	page := make([]byte, 28, 28+len(packet))
+	copy(page, "OggS")
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], serial)
+	page[26], page[27] = 1, byte(len(packet))
+	page = append(page, packet...)
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRC[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+func TestParseSound(t *testing.T) {
+	vorbis := append([]byte("\x01vorbis\x00\x00\x00\x00\x02"), 0x44, 0xac, 0, 0)
+	vorbis = append(vorbis, make([]byte, 14)...)
+	opus := []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	xing := mp3Frames(1, false)
+	copy(xing[36:], "Xing")
+	id3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x05"), "title"...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want soundInfo
+	}{
+		{"a.wav", encodeWAV(8000, 2, make([]int16, 8000)...), soundInfo{0.5, 8000, 2}},
+		{"a.WAV", encodeWAV(22050, 1), soundInfo{0, 22050, 1}},
+		{"a.mp3", mp3Frames(10, false), soundInfo{11520.0 / 44100, 44100, 2}},
+		{"b.mp3", append(append(id3, xing...), append(mp3Frames(5, true), "TAG..."...)...), soundInfo{5760.0 / 44100, 44100, 1}},
+		{"a.ogg", append(oggPage(7, 0, vorbis), append(oggPage(8, 999999, []byte("x")), oggPage(7, 88200, []byte("audio"))...)...), soundInfo{2, 44100, 2}},
+		{"b.ogg", append(oggPage(1, 0, opus), oggPage(1, 48000+312, []byte("audio"))...), soundInfo{1, 48000, 1}},
+	}
+	for _, tt := range tests {
+		info, err := parseSound(tt.name, tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if math.Abs(info.Duration-tt.want.Duration) > 1e-9 || info.SampleRate != tt.want.SampleRate || info.Channels != tt.want.Channels {
+			t.Errorf("%s: info = %+v, want %+v", tt.name, *info, tt.want)
+		}
+	}
+
+	truncated := encodeWAV(8000, 1, 1, 2, 3)
+	misaligned := encodeWAV(8000, 2, 1, 2)
+	binary.LittleEndian.PutUint16(misaligned[32:], 1)
+	slow := encodeWAV(8000, 1, 1, 2)
+	binary.LittleEndian.PutUint32(slow[24:], 1)
+	badCRC := oggPage(1, 0, opus)
+	badCRC[30]++
+	for name, data := range map[string][]byte{
+		"short.wav":  truncated[:len(truncated)-1],
+		"align.wav":  misaligned,
+		"slow.wav":   slow,
+		"nofmt.wav":  []byte("RIFF\x04\x00\x00\x00WAVE"),
+		"mp3.wav":    mp3Frames(2, false),
+		"junk.mp3":   []byte("not an mp3 at all"),
+		"cut.mp3":    mp3Frames(1, false)[:300],
+		"crc.ogg":    badCRC,
+		"cut.ogg":    oggPage(1, 0, opus)[:30],
+		"speex.ogg":  oggPage(1, 0, []byte("Speex   ")),
+		"sound.flac": []byte("fLaC"),
+	} {
+		var fileErr *FileError
+		if _, err := parseSound(name, data); !errors.As(err, &fileErr) || fileErr.Name != name {
+			t.Errorf("%s: err = %v, want a *FileError", name, err)
+		}
+	}
+}
+
+func TestResampleWAV(t *testing.T) {
+	data, err := resampleWAV(encodeWAV(8000, 2, 0, 1000, 100, -1000), 16000, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, samples, err := readWAV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.SampleRate != 16000 || format.Channels != 2 || format.ByteRate != 64000 {
+		t.Errorf("format = %+v", format)
+	}
+	want := []int16{0, 1000, 50, 0, 100, -1000, 100, -1000}
+	got := make([]int16, len(samples)/2)
+	binary.Read(bytes.NewReader(samples), binary.LittleEndian, got)
+	if len(data) != 44+len(samples) || !slices.Equal(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+
+	same := encodeWAV(16000, 1, 1, 2)
+	if data, err := resampleWAV(same, 16000, 1<<10); err != nil || !bytes.Equal(data, same) {
+		t.Errorf("resampling to the same rate changed the file: %v", err)
+	}
+
+	var limitErr *LimitError
+	if _, err := resampleWAV(encodeWAV(8000, 1, make([]int16, 100)...), 48000, 1<<10); !errors.As(err, &limitErr) {
+		t.Errorf("resampling past the limit: err = %v, want a *LimitError", err)
+	}
+}
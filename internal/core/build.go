@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// buildTimeout bounds the time Build may spend compiling a project.
+const buildTimeout = 10 * time.Minute
+
+// buildEnvKeys are the variables of the process environment passed on
+// to the toolchain. Anything else, such as the DSN and the bucket
+// credentials, must not reach the code of a user's go.mod.
+var buildEnvKeys = []string{
+	"PATH", "HOME", "TMPDIR", "GOROOT", "GOPATH", "GOCACHE", "GOMODCACHE",
+	"GOPROXY", "GOSUMDB", "GOPRIVATE", "GOFLAGS", "GOPROOT",
+}
+
+// spxVersion is the spx module required by projects that don't carry
+// their own go.mod.
+const spxVersion = "v1.0.0"
+
+type BuildResponse struct {
+	URL    string `json:"url"`
+	Logs   string `json:"logs"`
+	Cached bool   `json:"cached"`
+	Error  string `json:"error"`
+}
+
+// Build compiles the stored project to a WebAssembly bundle using the
+// local Go+ toolchain. Artifacts are cached in the bucket keyed by the
+// hash of the project bundle, so building an unchanged project is free.
+// The project must be visible to user uid. At most Config.MaxBuilds
+// projects are compiled at once; beyond that Build fails with ErrBusy.
+func (p *Project) Build(ctx context.Context, id, uid string) (*BuildResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, buildTimeout)
+	defer cancel()
+
+	c, err := p.FileInfo(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.readBlob(ctx, c.Address)
+	if err != nil {
+		return nil, err
+	}
+	wasmKey := p.conf.BuildPath + contentHash(data) + ".wasm"
+	logKey := wasmKey + ".log"
+
+	if ok, err := p.blobExists(ctx, wasmKey); err == nil && ok {
+		logs, _ := p.readBlob(ctx, logKey)
+		return &BuildResponse{
+			URL:    p.conf.QiniuPath + wasmKey,
+			Logs:   string(logs),
+			Cached: true,
+		}, nil
+	}
+
+	fs, err := unpackProject(data, p.limits)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case p.builds <- struct{}{}:
+		defer func() { <-p.builds }()
+	default:
+		return nil, ErrBusy
+	}
+	wasm, logs, err := buildWasm(ctx, fs)
+	if err != nil {
+		return &BuildResponse{Logs: logs, Error: err.Error()}, nil
+	}
+	if err = p.writeBlob(ctx, wasmKey, wasm); err != nil {
+		return nil, err
+	}
+	if err = p.writeBlob(ctx, logKey, []byte(logs)); err != nil {
+		return nil, err
+	}
+	return &BuildResponse{
+		URL:  p.conf.QiniuPath + wasmKey,
+		Logs: logs,
+	}, nil
+}
+
+// buildWasm writes fs out as a module in a temporary directory and
+// runs `gop build` on it for GOOS=js GOARCH=wasm. It returns the wasm
+// binary and the combined output of the toolchain.
+func buildWasm(ctx context.Context, fs *fileSet) ([]byte, string, error) {
+	tmpDir, err := os.MkdirTemp("", "gopbuild")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, f := range fs.files {
+		name := filepath.Join(tmpDir, filepath.FromSlash(f))
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return nil, "", err
+		}
+		if err = os.WriteFile(name, fs.Data(f), 0644); err != nil {
+			return nil, "", err
+		}
+	}
+	if !fs.Contains("go.mod") {
+		gomod := "module spxproject\n\ngo 1.21\n\nrequire github.com/goplus/spx " + spxVersion + " //gop:class\n"
+		if err = os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte(gomod), 0644); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var logs bytes.Buffer
+	out := filepath.Join(tmpDir, "prog.wasm")
+	steps := [][]string{
+		{"gop", "mod", "tidy"},
+		{"gop", "build", "-o", out, "."},
+	}
+	for _, args := range steps {
+		ctx, span := tracer.Start(ctx, strings.Join(args[:2], " "))
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = tmpDir
+		cmd.Env = buildEnv()
+		cmd.Stdout = &logs
+		cmd.Stderr = &logs
+		err = cmd.Run()
+		endSpan(span, err)
+		if err != nil {
+			return nil, logs.String(), err
+		}
+	}
+	wasm, err := os.ReadFile(out)
+	if err != nil {
+		return nil, logs.String(), err
+	}
+	return wasm, logs.String(), nil
+}
+
+// buildEnv returns the environment the toolchain runs with: the
+// variables named by buildEnvKeys and the wasm target.
+func buildEnv() []string {
+	var env []string
+	for _, k := range buildEnvKeys {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return append(env, "GOOS=js", "GOARCH=wasm")
+}
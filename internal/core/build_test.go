@@ -0,0 +1,23 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildEnv(t *testing.T) {
+	t.Setenv("GOPROXY", "https://proxy.example.com")
+	t.Setenv("GOP_SPX_DSN", "root:secret@tcp(db)/spx")
+	t.Setenv("QINIU_SECRET_KEY", "secret")
+	env := buildEnv()
+	for _, kv := range []string{"GOPROXY=https://proxy.example.com", "GOOS=js", "GOARCH=wasm"} {
+		if !slices.Contains(env, kv) {
+			t.Errorf("buildEnv() = %q, want it to contain %q", env, kv)
+		}
+	}
+	for _, kv := range env {
+		if kv == "GOP_SPX_DSN=root:secret@tcp(db)/spx" || kv == "QINIU_SECRET_KEY=secret" {
+			t.Errorf("buildEnv() passes on %q", kv)
+		}
+	}
+}
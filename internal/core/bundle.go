@@ -0,0 +1,132 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// zipMagic is the signature every zip archive starts with.
+var zipMagic = []byte("PK\x03\x04")
+
+// unpackProject turns a stored project bundle into a fileSet. Projects
+// are uploaded either as zip archives or as txtar documents; the format
+// is detected from the content, not from the file extension.
+func unpackProject(data []byte, lim fileLimits) (*fileSet, error) {
+	if bytes.HasPrefix(data, zipMagic) {
+		return unzipFiles(data, lim)
+	}
+	return splitFiles(data, lim)
+}
+
+// unzipFiles reads a zip archive into a fileSet. Directory entries are
+// skipped, and if every file lives under the same top-level directory
+// (as happens when a folder is zipped from a file manager) that
+// directory is stripped from the names. Files adding up to more than
+// lim.size bytes are rejected, whatever sizes the archive claims.
+func unzipFiles(data []byte, lim fileLimits) (*fileSet, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var entries []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		names = append(names, f.Name)
+		entries = append(entries, f)
+	}
+	if len(names) > lim.numFiles {
+		return nil, &LimitError{What: "number of files", Value: len(names), Limit: lim.numFiles}
+	}
+	prefix := commonDir(names)
+	fs := new(fileSet)
+	total := 0
+	for i, f := range entries {
+		name := strings.TrimPrefix(names[i], prefix)
+		if err := checkFileName(name, lim); err != nil {
+			return nil, err
+		}
+		if fs.Contains(name) {
+			return nil, &FileError{Name: name, Reason: "duplicate file name"}
+		}
+		if f.UncompressedSize64 > uint64(lim.size-total) {
+			return nil, &LimitError{What: "unpacked size", Value: total + int(min(f.UncompressedSize64, 1<<62)), Limit: lim.size}
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(io.LimitReader(rc, int64(lim.size-total)+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if total += len(b); total > lim.size {
+			return nil, &LimitError{What: "unpacked size", Value: total, Limit: lim.size}
+		}
+		fs.AddFile(name, b)
+	}
+	return fs, nil
+}
+
+// zipPrefix returns the top-level directory unzipFiles strips from the
+// names of the files of a zip archive, or "" if there is none.
+func zipPrefix(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
+		}
+	}
+	return commonDir(names), nil
+}
+
+// commonDir returns the top-level directory shared by all names,
+// including the trailing slash, or "" if there is none.
+func commonDir(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	dir, _, ok := strings.Cut(names[0], "/")
+	if !ok {
+		return ""
+	}
+	dir += "/"
+	for _, name := range names[1:] {
+		if !strings.HasPrefix(name, dir) {
+			return ""
+		}
+	}
+	return dir
+}
+
+// zipFiles packs fs into a zip archive. Files are written in name order
+// so the same fileSet always produces the same bytes.
+func zipFiles(fs *fileSet) ([]byte, error) {
+	names := append([]string(nil), fs.files...)
+	sort.Strings(names)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(fs.Data(name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
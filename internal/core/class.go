@@ -0,0 +1,394 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// joinCodeAlphabet leaves out the letters and digits that read alike,
+// as join codes are copied by hand.
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// joinCodeLen is the length of a join code.
+const joinCodeLen = 8
+
+// A Class is a group of students taught by a teacher. Students enroll
+// with its join code.
+type Class struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TeacherId string    `json:"teacherId"`
+	JoinCode  string    `json:"joinCode,omitempty"` // only shown to the teacher
+	Students  []string  `json:"students,omitempty"` // only shown to the teacher
+	CTime     time.Time `json:"cTime"`
+}
+
+// An Assignment asks the students of a class to work on their own fork
+// of a starter project.
+type Assignment struct {
+	ID          string     `json:"id"`
+	ClassID     string     `json:"classId"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	StarterID   string     `json:"starterId"` // the project students fork
+	Due         *time.Time `json:"due,omitempty"`
+	CTime       time.Time  `json:"cTime"`
+	ProjectID   string     `json:"projectId,omitempty"` // the fork of the caller, once accepted
+}
+
+// A Submission is the project of a student for an assignment.
+type Submission struct {
+	StudentId string     `json:"studentId"`
+	ProjectID string     `json:"projectId,omitempty"` // empty until the student accepts the assignment
+	UTime     *time.Time `json:"uTime,omitempty"`     // when the student last saved the project
+}
+
+func newJoinCode() (string, error) {
+	b := make([]byte, joinCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = joinCodeAlphabet[int(b[i])%len(joinCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// CreateClass creates a class taught by user uid.
+func (p *Project) CreateClass(ctx context.Context, uid, name string) (*Class, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidParam)
+	}
+	code, err := newJoinCode()
+	if err != nil {
+		return nil, err
+	}
+	c := &Class{Name: name, TeacherId: uid, JoinCode: code, Students: []string{}, CTime: time.Now()}
+	res, err := p.db.ExecContext(ctx, "INSERT INTO class (name, teacher_id, join_code, c_time) VALUES (?, ?, ?, ?)", c.Name, uid, code, c.CTime)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	c.ID = fmt.Sprint(id)
+	return c, nil
+}
+
+// class returns class id, and whether user uid is a student of it. Users
+// who neither teach nor attend the class get ErrNotExist.
+func (p *Project) class(ctx context.Context, id, uid string) (*Class, bool, error) {
+	c := &Class{ID: id}
+	query := "SELECT name, teacher_id, join_code, c_time FROM class WHERE id = ?"
+	err := p.db.QueryRowContext(ctx, query, id).Scan(&c.Name, &c.TeacherId, &c.JoinCode, &c.CTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, ErrNotExist
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if uid == c.TeacherId {
+		return c, false, nil
+	}
+	var n int
+	query = "SELECT COUNT(*) FROM class_member WHERE class_id = ? AND student_id = ?"
+	if err = p.db.QueryRowContext(ctx, query, id, uid).Scan(&n); err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		return nil, false, ErrNotExist
+	}
+	c.JoinCode = ""
+	return c, true, nil
+}
+
+// Class returns class id as user uid sees it. Only the teacher gets the
+// join code and the students.
+func (p *Project) Class(ctx context.Context, id, uid string) (*Class, error) {
+	c, student, err := p.class(ctx, id, uid)
+	if err != nil || student {
+		return c, err
+	}
+	rows, err := p.db.QueryContext(ctx, "SELECT student_id FROM class_member WHERE class_id = ? ORDER BY c_time, student_id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c.Students = []string{}
+	for rows.Next() {
+		var student string
+		if err := rows.Scan(&student); err != nil {
+			return nil, err
+		}
+		c.Students = append(c.Students, student)
+	}
+	return c, rows.Err()
+}
+
+// Classes lists the classes user uid teaches or attends, newest first.
+func (p *Project) Classes(ctx context.Context, uid string) ([]*Class, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	query := "SELECT id, name, teacher_id, join_code, c_time FROM class WHERE teacher_id = ? OR id IN " +
+		"(SELECT class_id FROM class_member WHERE student_id = ?) ORDER BY c_time DESC, id DESC"
+	rows, err := p.db.QueryContext(ctx, query, uid, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	classes := []*Class{}
+	for rows.Next() {
+		c := &Class{}
+		if err := rows.Scan(&c.ID, &c.Name, &c.TeacherId, &c.JoinCode, &c.CTime); err != nil {
+			return nil, err
+		}
+		if c.TeacherId != uid {
+			c.JoinCode = ""
+		}
+		classes = append(classes, c)
+	}
+	return classes, rows.Err()
+}
+
+// JoinClass enrolls user uid in the class with join code, in any case.
+// Joining a class twice does nothing.
+func (p *Project) JoinClass(ctx context.Context, code, uid string) (*Class, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	var id, teacher string
+	err := p.db.QueryRowContext(ctx, "SELECT id, teacher_id FROM class WHERE join_code = ?", strings.ToUpper(strings.TrimSpace(code))).Scan(&id, &teacher)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: no class with join code %q", ErrInvalidParam, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uid == teacher {
+		return nil, fmt.Errorf("%w: teachers can't join their own class", ErrInvalidParam)
+	}
+	var n int
+	query := "SELECT COUNT(*) FROM class_member WHERE class_id = ? AND student_id = ?"
+	if err = p.db.QueryRowContext(ctx, query, id, uid).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		_, err = p.db.ExecContext(ctx, "INSERT INTO class_member (class_id, student_id, c_time) VALUES (?, ?, ?)", id, uid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.Class(ctx, id, uid)
+}
+
+// RemoveStudent removes student from class id. The teacher may remove
+// any student, and students may leave. Their submissions are kept.
+func (p *Project) RemoveStudent(ctx context.Context, id, uid, student string) error {
+	c, _, err := p.class(ctx, id, uid)
+	if err != nil {
+		return err
+	}
+	if uid != c.TeacherId && uid != student {
+		return ErrForbidden
+	}
+	_, err = p.db.ExecContext(ctx, "DELETE FROM class_member WHERE class_id = ? AND student_id = ?", id, student)
+	return err
+}
+
+// CreateAssignment adds an assignment to class id, which must be taught
+// by user uid. The starter project must be visible to uid; students get
+// their copy whatever its visibility. An empty due means no deadline.
+func (p *Project) CreateAssignment(ctx context.Context, id, uid, title, description, starterID, due string) (*Assignment, error) {
+	if strings.TrimSpace(title) == "" {
+		return nil, fmt.Errorf("%w: title is required", ErrInvalidParam)
+	}
+	a := &Assignment{ClassID: id, Title: title, Description: description, StarterID: starterID, CTime: time.Now()}
+	if due != "" {
+		t, err := time.Parse(time.RFC3339, due)
+		if err != nil {
+			return nil, fmt.Errorf("%w: due %q is not an RFC 3339 time", ErrInvalidParam, due)
+		}
+		a.Due = &t
+	}
+	c, _, err := p.class(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	if uid != c.TeacherId {
+		return nil, ErrForbidden
+	}
+	if _, err = p.FileInfo(ctx, starterID, uid); errors.Is(err, ErrNotExist) {
+		return nil, fmt.Errorf("%w: no starter project %q", ErrInvalidParam, starterID)
+	} else if err != nil {
+		return nil, err
+	}
+	var dueTime sql.NullTime
+	if a.Due != nil {
+		dueTime = sql.NullTime{Time: *a.Due, Valid: true}
+	}
+	query := "INSERT INTO assignment (class_id, title, description, starter_id, due_time, c_time) VALUES (?, ?, ?, ?, ?, ?)"
+	res, err := p.db.ExecContext(ctx, query, id, title, description, starterID, dueTime, a.CTime)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	a.ID = fmt.Sprint(n)
+	return a, nil
+}
+
+// Assignments lists the assignments of class id, newest first. Students
+// get the ID of their project for the assignments they accepted.
+func (p *Project) Assignments(ctx context.Context, id, uid string) ([]*Assignment, error) {
+	if _, _, err := p.class(ctx, id, uid); err != nil {
+		return nil, err
+	}
+	query := "SELECT a.id, a.title, a.description, a.starter_id, a.due_time, a.c_time, s.project_id FROM assignment a " +
+		"LEFT JOIN submission s ON s.assignment_id = a.id AND s.student_id = ? WHERE a.class_id = ? ORDER BY a.c_time DESC, a.id DESC"
+	rows, err := p.db.QueryContext(ctx, query, uid, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	assignments := []*Assignment{}
+	for rows.Next() {
+		a := &Assignment{ClassID: id}
+		var due sql.NullTime
+		var project sql.NullString
+		if err := rows.Scan(&a.ID, &a.Title, &a.Description, &a.StarterID, &due, &a.CTime, &project); err != nil {
+			return nil, err
+		}
+		if due.Valid {
+			a.Due = &due.Time
+		}
+		a.ProjectID = project.String
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+// assignment returns assignment id along with its class as user uid
+// sees it.
+func (p *Project) assignment(ctx context.Context, id, uid string) (a *Assignment, c *Class, student bool, err error) {
+	a = &Assignment{ID: id}
+	var due sql.NullTime
+	query := "SELECT class_id, title, description, starter_id, due_time, c_time FROM assignment WHERE id = ?"
+	err = p.db.QueryRowContext(ctx, query, id).Scan(&a.ClassID, &a.Title, &a.Description, &a.StarterID, &due, &a.CTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, false, ErrNotExist
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if due.Valid {
+		a.Due = &due.Time
+	}
+	if c, student, err = p.class(ctx, a.ClassID, uid); err != nil {
+		return nil, nil, false, err
+	}
+	return a, c, student, nil
+}
+
+// AcceptAssignment gives user uid, a student of the class of assignment
+// id, their own unlisted fork of the starter project, or returns the one
+// they already have. The fork is a regular project of uid, made like any
+// other fork, which uid saves with SaveProject. When two accepts race,
+// the fork whose submission isn't recorded is removed.
+func (p *Project) AcceptAssignment(ctx context.Context, id, uid string) (*CodeFile, error) {
+	a, _, student, err := p.assignment(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	if !student {
+		return nil, fmt.Errorf("%w: only students accept assignments", ErrInvalidParam)
+	}
+	var project string
+	query := "SELECT project_id FROM submission WHERE assignment_id = ? AND student_id = ?"
+	err = p.db.QueryRowContext(ctx, query, id, uid).Scan(&project)
+	if err == nil {
+		return p.FileInfo(ctx, project, uid)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	src, err := p.fileInfo(ctx, a.StarterID)
+	if err != nil {
+		return nil, err
+	}
+	fork, err := p.fork(ctx, src, uid, VisibilityUnlisted)
+	if err != nil {
+		return nil, err
+	}
+	insert := "INSERT INTO submission (assignment_id, student_id, project_id, c_time) VALUES (?, ?, ?, ?)"
+	if _, err = p.db.ExecContext(ctx, insert, id, uid, fork.ID, time.Now()); err != nil {
+		p.removeProject(ctx, fork)
+		if p.db.QueryRowContext(ctx, query, id, uid).Scan(&project) == nil {
+			return p.FileInfo(ctx, project, uid)
+		}
+		return nil, err
+	}
+	return fork, nil
+}
+
+// removeProject deletes project c, its revisions and its bundle, for a
+// project that was never handed out. Failures are only logged: what is
+// left is unreachable.
+func (p *Project) removeProject(ctx context.Context, c *CodeFile) {
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM project_revision WHERE project_id = ?", c.ID); err != nil {
+		p.log.WarnContext(ctx, "revisions not removed", "project", c.ID, "err", err)
+	}
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM project WHERE id = ?", c.ID); err != nil {
+		p.log.WarnContext(ctx, "project not removed", "project", c.ID, "err", err)
+	}
+	if err := p.deleteBlob(ctx, c.Address); err != nil {
+		p.log.WarnContext(ctx, "bundle not removed", "key", c.Address, "err", err)
+	}
+}
+
+// Submissions lists the project of every student of the class of
+// assignment id, which must be taught by user uid, with when it was last
+// saved.
+func (p *Project) Submissions(ctx context.Context, id, uid string) ([]*Submission, error) {
+	a, c, _, err := p.assignment(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	if uid != c.TeacherId {
+		return nil, ErrForbidden
+	}
+	query := "SELECT m.student_id, s.project_id, pr.u_time FROM class_member m " +
+		"LEFT JOIN submission s ON s.assignment_id = ? AND s.student_id = m.student_id " +
+		"LEFT JOIN project pr ON pr.id = s.project_id WHERE m.class_id = ? ORDER BY m.student_id"
+	rows, err := p.db.QueryContext(ctx, query, id, a.ClassID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	submissions := []*Submission{}
+	for rows.Next() {
+		s := &Submission{}
+		var project sql.NullString
+		var utime sql.NullTime
+		if err := rows.Scan(&s.StudentId, &project, &utime); err != nil {
+			return nil, err
+		}
+		s.ProjectID = project.String
+		if utime.Valid {
+			s.UTime = &utime.Time
+		}
+		submissions = append(submissions, s)
+	}
+	return submissions, rows.Err()
+}
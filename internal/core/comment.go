@@ -0,0 +1,244 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Statuses of a comment.
+const (
+	CommentDeleted = 0
+	CommentVisible = 1
+	CommentHidden  = 2 // reported Config.CommentReportLimit times
+)
+
+// maxCommentLen is the max number of characters of a comment.
+const maxCommentLen = 2000
+
+// maxReportReasonLen is the max number of characters of the reason of
+// a report, the size of its column.
+const maxReportReasonLen = 255
+
+// maxComments bounds the number of comments Comments returns.
+const maxComments = 1000
+
+// A Comment is a comment on a project, along with the replies to it.
+// Deleted comments are listed with an empty body as long as they have
+// replies.
+type Comment struct {
+	ID        string     `json:"id"`
+	ProjectID string     `json:"projectId"`
+	ParentID  string     `json:"parentId,omitempty"` // the comment this one replies to
+	AuthorId  string     `json:"authorId"`
+	Body      string     `json:"body"`
+	Status    int        `json:"status"` // CommentVisible, CommentHidden or CommentDeleted
+	CTime     time.Time  `json:"cTime"`
+	UTime     time.Time  `json:"uTime"`
+	Replies   []*Comment `json:"replies"`
+}
+
+// checkComment rejects empty and overlong comments, and those that
+// contain a word of Config.BlockedWords.
+func (p *Project) checkComment(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return fmt.Errorf("%w: comment is empty", ErrInvalidParam)
+	}
+	if n := utf8.RuneCountInString(body); n > maxCommentLen {
+		return &LimitError{What: "comment length", Value: n, Limit: maxCommentLen}
+	}
+	lower := strings.ToLower(body)
+	for _, word := range p.conf.blockedWords() {
+		if strings.Contains(lower, word) {
+			return fmt.Errorf("%w: comment contains a blocked word", ErrInvalidParam)
+		}
+	}
+	return nil
+}
+
+// comment returns comment id, along with the project it's on as user
+// uid sees it. Deleted comments don't exist.
+func (p *Project) comment(ctx context.Context, id, uid string) (*Comment, *CodeFile, error) {
+	c := &Comment{ID: id, Replies: []*Comment{}}
+	var parentID sql.NullString
+	query := "SELECT project_id, parent_id, author_id, body, status, c_time, u_time FROM comment WHERE id = ? AND status != ?"
+	err := p.db.QueryRowContext(ctx, query, id, CommentDeleted).Scan(&c.ProjectID, &parentID, &c.AuthorId, &c.Body, &c.Status, &c.CTime, &c.UTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	c.ParentID = parentID.String
+	project, err := p.FileInfo(ctx, c.ProjectID, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, project, nil
+}
+
+// AddComment adds a comment of user uid on project id, in reply to
+// comment parentID unless it's empty.
+func (p *Project) AddComment(ctx context.Context, id, uid, parentID, body string) (*Comment, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	if err := p.checkComment(body); err != nil {
+		return nil, err
+	}
+	if _, err := p.FileInfo(ctx, id, uid); err != nil {
+		return nil, err
+	}
+	if parentID != "" {
+		parent, _, err := p.comment(ctx, parentID, uid)
+		if errors.Is(err, ErrNotExist) || err == nil && parent.ProjectID != id {
+			return nil, fmt.Errorf("%w: no comment %s on project %s", ErrInvalidParam, parentID, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	c := &Comment{ProjectID: id, ParentID: parentID, AuthorId: uid, Body: body, Status: CommentVisible, CTime: now, UTime: now, Replies: []*Comment{}}
+	query := "INSERT INTO comment (project_id, parent_id, author_id, body, status, c_time, u_time) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	res, err := p.db.ExecContext(ctx, query, id, sql.NullString{String: parentID, Valid: parentID != ""}, uid, body, c.Status, now, now)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	c.ID = fmt.Sprint(n)
+	return c, nil
+}
+
+// EditComment replaces the body of comment id, which must be written by
+// user uid.
+func (p *Project) EditComment(ctx context.Context, id, uid, body string) (*Comment, error) {
+	if err := p.checkComment(body); err != nil {
+		return nil, err
+	}
+	c, _, err := p.comment(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	if uid == "" || uid != c.AuthorId {
+		return nil, ErrForbidden
+	}
+	c.Body, c.UTime = body, time.Now()
+	_, err = p.db.ExecContext(ctx, "UPDATE comment SET body = ?, u_time = ? WHERE id = ?", c.Body, c.UTime, id)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DeleteComment deletes comment id. Its author and the owner of the
+// project may delete it. Replies to it are kept.
+func (p *Project) DeleteComment(ctx context.Context, id, uid string) error {
+	c, project, err := p.comment(ctx, id, uid)
+	if err != nil {
+		return err
+	}
+	if uid == "" || uid != c.AuthorId && uid != project.AuthorId {
+		return ErrForbidden
+	}
+	_, err = p.db.ExecContext(ctx, "UPDATE comment SET status = ?, u_time = ? WHERE id = ?", CommentDeleted, time.Now(), id)
+	return err
+}
+
+// ReportComment records that user uid at ip reports comment id for
+// reason, which may be empty. A user reports a comment at most once, and
+// the comment is hidden once users at Config.CommentReportLimit
+// different addresses reported it, see mark.
+func (p *Project) ReportComment(ctx context.Context, id, uid, ip, reason string) error {
+	if uid == "" {
+		return fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	if n := utf8.RuneCountInString(reason); n > maxReportReasonLen {
+		return &LimitError{What: "report reason length", Value: n, Limit: maxReportReasonLen}
+	}
+	c, _, err := p.comment(ctx, id, uid)
+	if err != nil {
+		return err
+	}
+	count, err := commentReports.set(ctx, p, id, uid, ip, true)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		_, err = p.db.ExecContext(ctx, "UPDATE comment_report SET reason = ? WHERE comment_id = ? AND user_id = ?", reason, id, uid)
+		if err != nil {
+			return err
+		}
+	}
+	if count >= int64(p.conf.CommentReportLimit) && c.Status == CommentVisible {
+		_, err = p.db.ExecContext(ctx, "UPDATE comment SET status = ? WHERE id = ?", CommentHidden, id)
+		if err != nil {
+			return err
+		}
+		p.log.InfoContext(ctx, "comment hidden", "comment", id, "reports", count)
+	}
+	return nil
+}
+
+// Comments returns the threads of comments on project id as user uid
+// sees them, oldest first. Hidden comments have an empty body for all
+// but the owner of the project. Past maxComments comments the list is
+// cut short.
+func (p *Project) Comments(ctx context.Context, id, uid string) ([]*Comment, error) {
+	project, err := p.FileInfo(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT id, parent_id, author_id, body, status, c_time, u_time FROM comment WHERE project_id = ? ORDER BY id LIMIT ?"
+	rows, err := p.db.QueryContext(ctx, query, id, maxComments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []*Comment
+	byID := make(map[string]*Comment)
+	for rows.Next() {
+		c := &Comment{ProjectID: id, Replies: []*Comment{}}
+		var parentID sql.NullString
+		if err := rows.Scan(&c.ID, &parentID, &c.AuthorId, &c.Body, &c.Status, &c.CTime, &c.UTime); err != nil {
+			return nil, err
+		}
+		c.ParentID = parentID.String
+		if c.Status == CommentDeleted || c.Status == CommentHidden && uid != project.AuthorId {
+			c.Body = ""
+		}
+		all = append(all, c)
+		byID[c.ID] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	threads := []*Comment{}
+	for _, c := range all {
+		if parent := byID[c.ParentID]; parent != nil {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			threads = append(threads, c)
+		}
+	}
+	return pruneComments(threads), nil
+}
+
+// pruneComments drops the deleted comments left without replies.
+func pruneComments(cs []*Comment) []*Comment {
+	kept := cs[:0]
+	for _, c := range cs {
+		c.Replies = pruneComments(c.Replies)
+		if c.Status != CommentDeleted || len(c.Replies) > 0 {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,344 @@
+package core
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	Driver string // database driver. default is `mysql`.
+	DSN    string // database data source name
+	BlobUS string // blob URL scheme
+
+	QiniuPath   string // URL prefix the bucket is served from
+	ProjectPath string // key prefix of project bundles. default is `project/`.
+	SpiritPath  string // key prefix of sprite assets. default is `spirit/`.
+	BuildPath   string // key prefix of build artifacts. default is `build/`.
+
+	ListenAddr      string        // address the API listens on. default is `:8080`.
+	ShutdownTimeout time.Duration // how long to wait for in-flight requests on shutdown. default is 30s.
+	CORSOrigins     string        // comma separated origins allowed to call the API, or `*`. default is `*`.
+
+	MaxOpenConns    int           // max open database connections. default is 20.
+	MaxIdleConns    int           // max idle database connections. default is 10.
+	ConnMaxLifetime time.Duration // max lifetime of a database connection. default is 30m.
+	ConnMaxIdleTime time.Duration // max idle time of a database connection. default is 5m.
+
+	MaxFiles       int // max number of files in a project archive. default is 200.
+	MaxFileNameLen int // max length of a file name in a project archive. default is 200.
+	MaxFileDepth   int // max number of path elements of a file name. default is 10.
+	MaxUnpackedMB  int // max size of the files of a project archive once unpacked, in MiB. default is 64.
+
+	RateLimits     string // per route token buckets, see parseRateLimits. default is defaultRateLimits.
+	StorageQuotaMB int    // storage quota of a user in MiB. default is 100.
+
+	LogFormat     string // `text` or `json`. default is `text`.
+	LogLevel      string // `debug`, `info`, `warn` or `error`. default is `info`.
+	TraceExporter string // `none`, `stdout` or `otlp`. default is `none`.
+
+	TrendingInterval time.Duration // how often trending scores of the gallery are recomputed. default is 10m.
+
+	BlockedWords       string // comma separated words comments may not contain, in any case. default is none.
+	CommentReportLimit int    // reports after which a comment is hidden. default is 3.
+
+	SoundSampleRate int // rate 16-bit PCM WAV sounds are resampled to when uploaded, 0 keeps them as they are. default is 0.
+
+	MaxBuilds int // max number of projects built at once. default is 2.
+}
+
+// defaultConfigFiles are the env files tried when -config isn't given.
+// ../.env is where the service historically looked when run from cmd.
+var defaultConfigFiles = []string{".env", "../.env"}
+
+// A setting binds a Config field to its env key and command line flag.
+type setting struct {
+	key   string
+	flag  string
+	usage string
+	str   *string
+	num   *int
+	dur   *time.Duration
+	zero  bool // whether 0 is a valid num, turning what it sets off
+}
+
+func (s *setting) set(v string) error {
+	if s.str != nil {
+		*s.str = v
+		return nil
+	}
+	if s.dur != nil {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", s.key, v)
+		}
+		*s.dur = d
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a number", s.key, v)
+	}
+	*s.num = n
+	return nil
+}
+
+func (conf *Config) settings() []*setting {
+	return []*setting{
+		{key: "GOP_SPX_DRIVER", flag: "driver", usage: "database driver", str: &conf.Driver},
+		{key: "GOP_SPX_DSN", flag: "dsn", usage: "database data source name", str: &conf.DSN},
+		{key: "GOP_SPX_BLOBUS", flag: "blob", usage: "blob URL scheme", str: &conf.BlobUS},
+		{key: "QINIU_PATH", flag: "qiniu-path", usage: "URL prefix the bucket is served from", str: &conf.QiniuPath},
+		{key: "PROJECT_PATH", flag: "project-path", usage: "key prefix of project bundles", str: &conf.ProjectPath},
+		{key: "SPIRIT_PATH", flag: "spirit-path", usage: "key prefix of sprite assets", str: &conf.SpiritPath},
+		{key: "BUILD_PATH", flag: "build-path", usage: "key prefix of build artifacts", str: &conf.BuildPath},
+		{key: "LISTEN_ADDR", flag: "listen", usage: "address to listen on", str: &conf.ListenAddr},
+		{key: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", usage: "how long to wait for in-flight requests on shutdown", dur: &conf.ShutdownTimeout},
+		{key: "CORS_ORIGINS", flag: "cors-origins", usage: "comma separated origins allowed to call the API, or *", str: &conf.CORSOrigins},
+		{key: "DB_MAX_OPEN_CONNS", flag: "db-max-open-conns", usage: "max open database connections", num: &conf.MaxOpenConns},
+		{key: "DB_MAX_IDLE_CONNS", flag: "db-max-idle-conns", usage: "max idle database connections", num: &conf.MaxIdleConns},
+		{key: "DB_CONN_MAX_LIFETIME", flag: "db-conn-max-lifetime", usage: "max lifetime of a database connection", dur: &conf.ConnMaxLifetime},
+		{key: "DB_CONN_MAX_IDLE_TIME", flag: "db-conn-max-idle-time", usage: "max idle time of a database connection", dur: &conf.ConnMaxIdleTime},
+		{key: "MAX_FILES", flag: "max-files", usage: "max number of files in a project archive", num: &conf.MaxFiles},
+		{key: "MAX_FILE_NAME_LEN", flag: "max-file-name-len", usage: "max length of a file name in a project archive", num: &conf.MaxFileNameLen},
+		{key: "MAX_FILE_DEPTH", flag: "max-file-depth", usage: "max number of path elements of a file name", num: &conf.MaxFileDepth},
+		{key: "MAX_UNPACKED_MB", flag: "max-unpacked-mb", usage: "max size of the files of a project archive once unpacked, in MiB", num: &conf.MaxUnpackedMB},
+		{key: "RATE_LIMITS", flag: "rate-limits", usage: "per route rate limits, as route=rate:burst,...", str: &conf.RateLimits},
+		{key: "STORAGE_QUOTA_MB", flag: "storage-quota-mb", usage: "storage quota of a user in MiB", num: &conf.StorageQuotaMB},
+		{key: "LOG_FORMAT", flag: "log-format", usage: "log format: text or json", str: &conf.LogFormat},
+		{key: "LOG_LEVEL", flag: "log-level", usage: "minimum log level: debug, info, warn or error", str: &conf.LogLevel},
+		{key: "TRACE_EXPORTER", flag: "trace-exporter", usage: "where to export traces: none, stdout or otlp", str: &conf.TraceExporter},
+		{key: "TRENDING_INTERVAL", flag: "trending-interval", usage: "how often trending scores of the gallery are recomputed", dur: &conf.TrendingInterval},
+		{key: "BLOCKED_WORDS", flag: "blocked-words", usage: "comma separated words comments may not contain", str: &conf.BlockedWords},
+		{key: "COMMENT_REPORT_LIMIT", flag: "comment-report-limit", usage: "reports after which a comment is hidden", num: &conf.CommentReportLimit},
+		{key: "SOUND_SAMPLE_RATE", flag: "sound-sample-rate", usage: "sample rate WAV sounds are resampled to, 0 to keep them", num: &conf.SoundSampleRate, zero: true},
+		{key: "MAX_BUILDS", flag: "max-builds", usage: "max number of projects built at once", num: &conf.MaxBuilds},
+	}
+}
+
+// LoadConfig builds a Config from, in increasing order of precedence,
+// the defaults, an env file, the process environment and the command
+// line flags in args. The env file is the one named by -config, or the
+// first of defaultConfigFiles that exists. The result is validated.
+func LoadConfig(args []string) (*Config, error) {
+	conf := new(Config)
+	conf.setDefaults()
+	settings := conf.settings()
+
+	fset := flag.NewFlagSet("spx-back", flag.ContinueOnError)
+	file := fset.String("config", "", "env `file` to read settings from")
+	flags := make(map[*setting]string)
+	for _, s := range settings {
+		s := s
+		fset.Func(s.flag, s.usage+" ($"+s.key+")", func(v string) error {
+			flags[s] = v
+			return nil
+		})
+	}
+	if err := fset.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var env map[string]string
+	if *file != "" {
+		m, err := godotenv.Read(*file)
+		if err != nil {
+			return nil, err
+		}
+		env = m
+	} else {
+		for _, name := range defaultConfigFiles {
+			if m, err := godotenv.Read(name); err == nil {
+				env = m
+				break
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := env[s.key]; ok {
+			if err := s.set(v); err != nil {
+				return nil, err
+			}
+		}
+		if v, ok := os.LookupEnv(s.key); ok {
+			if err := s.set(v); err != nil {
+				return nil, err
+			}
+		}
+		if v, ok := flags[s]; ok {
+			if err := s.set(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// setDefaults fills in the zero fields of conf that have a default.
+func (conf *Config) setDefaults() {
+	if conf.Driver == "" {
+		conf.Driver = "mysql"
+	}
+	if conf.ProjectPath == "" {
+		conf.ProjectPath = "project/"
+	}
+	if conf.SpiritPath == "" {
+		conf.SpiritPath = "spirit/"
+	}
+	if conf.BuildPath == "" {
+		conf.BuildPath = "build/"
+	}
+	if conf.ListenAddr == "" {
+		conf.ListenAddr = ":8080"
+	}
+	if conf.ShutdownTimeout == 0 {
+		conf.ShutdownTimeout = 30 * time.Second
+	}
+	if conf.CORSOrigins == "" {
+		conf.CORSOrigins = "*"
+	}
+	if conf.MaxOpenConns == 0 {
+		conf.MaxOpenConns = 20
+	}
+	if conf.MaxIdleConns == 0 {
+		conf.MaxIdleConns = 10
+	}
+	if conf.ConnMaxLifetime == 0 {
+		conf.ConnMaxLifetime = 30 * time.Minute
+	}
+	if conf.ConnMaxIdleTime == 0 {
+		conf.ConnMaxIdleTime = 5 * time.Minute
+	}
+	if conf.MaxFiles == 0 {
+		conf.MaxFiles = 200
+	}
+	if conf.MaxFileNameLen == 0 {
+		conf.MaxFileNameLen = 200
+	}
+	if conf.MaxFileDepth == 0 {
+		conf.MaxFileDepth = 10
+	}
+	if conf.MaxUnpackedMB == 0 {
+		conf.MaxUnpackedMB = 64
+	}
+	if conf.RateLimits == "" {
+		conf.RateLimits = defaultRateLimits
+	}
+	if conf.StorageQuotaMB == 0 {
+		conf.StorageQuotaMB = 100
+	}
+	if conf.LogFormat == "" {
+		conf.LogFormat = "text"
+	}
+	if conf.LogLevel == "" {
+		conf.LogLevel = "info"
+	}
+	if conf.TraceExporter == "" {
+		conf.TraceExporter = "none"
+	}
+	if conf.TrendingInterval == 0 {
+		conf.TrendingInterval = 10 * time.Minute
+	}
+	if conf.CommentReportLimit == 0 {
+		conf.CommentReportLimit = 3
+	}
+	if conf.MaxBuilds == 0 {
+		conf.MaxBuilds = 2
+	}
+}
+
+// Validate reports every required setting that is missing and every
+// setting with an unusable value.
+func (conf *Config) Validate() error {
+	var errs []string
+	for _, s := range conf.settings() {
+		switch {
+		case s.str != nil && *s.str == "" && requiredSettings[s.key]:
+			errs = append(errs, fmt.Sprintf("%s (-%s) is required", s.key, s.flag))
+		case s.num != nil && *s.num == 0 && s.zero:
+			// turned off
+		case s.num != nil && *s.num <= 0, s.dur != nil && *s.dur <= 0:
+			errs = append(errs, fmt.Sprintf("%s (-%s) must be positive", s.key, s.flag))
+		}
+	}
+	for _, s := range []struct{ key, v string }{
+		{"PROJECT_PATH", conf.ProjectPath},
+		{"SPIRIT_PATH", conf.SpiritPath},
+		{"BUILD_PATH", conf.BuildPath},
+	} {
+		if s.v != "" && !strings.HasSuffix(s.v, "/") {
+			errs = append(errs, fmt.Sprintf("%s must end with /", s.key))
+		}
+	}
+	for _, s := range []struct {
+		key, v  string
+		allowed []string
+	}{
+		{"LOG_FORMAT", conf.LogFormat, []string{"text", "json"}},
+		{"LOG_LEVEL", conf.LogLevel, []string{"debug", "info", "warn", "error"}},
+		{"TRACE_EXPORTER", conf.TraceExporter, []string{"none", "stdout", "otlp"}},
+	} {
+		if s.v != "" && !slices.Contains(s.allowed, s.v) {
+			errs = append(errs, fmt.Sprintf("%s must be one of %s", s.key, strings.Join(s.allowed, ", ")))
+		}
+	}
+	for _, origin := range conf.corsOrigins() {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Sprintf("CORS_ORIGINS: %q is not an origin such as https://example.com", origin))
+		}
+	}
+	if r := conf.SoundSampleRate; r != 0 && (r < 8000 || r > 192000) {
+		errs = append(errs, "SOUND_SAMPLE_RATE must be 0 or from 8000 to 192000")
+	}
+	if _, err := parseRateLimits(conf.RateLimits); err != nil {
+		errs = append(errs, "RATE_LIMITS: "+err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// requiredSettings lists the settings that have no usable default.
+var requiredSettings = map[string]bool{
+	"GOP_SPX_DSN":    true,
+	"GOP_SPX_BLOBUS": true,
+	"QINIU_PATH":     true,
+}
+
+// blockedWords splits BlockedWords into lower case words.
+func (conf *Config) blockedWords() []string {
+	var words []string
+	for _, word := range strings.Split(conf.BlockedWords, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, strings.ToLower(word))
+		}
+	}
+	return words
+}
+
+// corsOrigins splits CORSOrigins into the allowed origins.
+func (conf *Config) corsOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(conf.CORSOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMaxAge is how long browsers may cache the answer to a preflight
+// request.
+const corsMaxAge = 10 * time.Minute
+
+// corsHeaders are the request headers browsers may send cross-origin,
+// besides the CORS-safelisted ones.
+var corsHeaders = []string{"Content-Type", "Authorization", "X-User-Id", requestIDHeader}
+
+// CORS wraps h to let the origins in Config.CORSOrigins call the API
+// from a browser, answering preflight requests itself. Listed origins
+// may send credentials; with `*` any origin is allowed, but without
+// credentials.
+func (p *Project) CORS(h http.Handler) http.Handler {
+	origins := p.conf.corsOrigins()
+	anyOrigin := slices.Contains(origins, "*")
+	maxAge := strconv.Itoa(int(corsMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		switch {
+		case slices.Contains(origins, origin):
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Credentials", "true")
+		case anyOrigin:
+			header.Set("Access-Control-Allow-Origin", "*")
+		default:
+			// Not allowed: answer without CORS headers and let the
+			// browser block the response.
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		header.Set("Access-Control-Expose-Headers", requestIDHeader)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", strings.Join(corsHeaders, ", "))
+			header.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 3
+
+// maxDiffEdits bounds the number of lines added and removed diffLines
+// looks for. Its memory grows with the square of that number, so files
+// that differ more are only reported as different.
+const maxDiffEdits = 1000
+
+// A diffOp is one line of an edit script: kind is ' ' for a line kept,
+// '-' for a line deleted from a and '+' for a line inserted from b.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the unified diff turning a into b, or "" if they
+// have the same lines. Files too different to diff, see maxDiffEdits,
+// get a single line saying so instead.
+func unifiedDiff(oldName, newName string, a, b []byte) string {
+	ops, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ (too many changes to show)\n", oldName, newName)
+	}
+	var sb strings.Builder
+	// i indexes ops; ai and bi are the line numbers (0-based) in a and b
+	// of ops[i].
+	ai, bi := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i, ai, bi = i+1, ai+1, bi+1
+			continue
+		}
+		// Found a change; back up to include the leading context.
+		start := i
+		for n := 0; n < diffContext && start > 0; n++ {
+			start--
+			ai, bi = ai-1, bi-1
+		}
+		// Extend the hunk over changes separated by at most
+		// diffContext*2 unchanged lines, then add the trailing context.
+		end := i + 1
+		for j, same := end, 0; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end, same = j+1, 0
+				continue
+			}
+			if same++; same > diffContext*2 {
+				break
+			}
+		}
+		for n := 0; n < diffContext && end < len(ops); n++ {
+			end++
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		var na, nb int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				na++
+			}
+			if op.kind != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(ai, na), hunkRange(bi, nb))
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i, ai, bi = end, ai+na, bi+nb
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start,count pair of a hunk header. start is
+// 0-based; unified diffs number lines from 1, and an empty range is
+// reported at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits data into lines without their terminating newline.
+func splitLines(data []byte) []string {
+	s := string(data)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script from a to b using Myers'
+// O(ND) algorithm. Only the diagonals reached so far are kept for each
+// step, which takes O(D²) memory; ok is false if the script would be
+// longer than maxDiffEdits.
+func diffLines(a, b []string) (ops []diffOp, ok bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v[off-d-1 : off+d+2] as it was before step d.
+	var trace [][]int
+search:
+	for d := 0; d <= max; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edits.
+	ops = make([]diffOp, 0, max)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, off := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
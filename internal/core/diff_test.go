@@ -0,0 +1,29 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\n"
+	b := "a\nc\nd\ne\n"
+	want := "--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n a\n-b\n c\n d\n+e\n"
+	if got := unifiedDiff("a/f", "b/f", []byte(a), []byte(b)); got != want {
+		t.Errorf("unifiedDiff = %q, want %q", got, want)
+	}
+	if got := unifiedDiff("a/f", "b/f", []byte(a), []byte(a)); got != "" {
+		t.Errorf("unifiedDiff of equal files = %q", got)
+	}
+
+	var old, new strings.Builder
+	for i := 0; i < maxDiffEdits; i++ {
+		fmt.Fprintf(&old, "old %d\n", i)
+		fmt.Fprintf(&new, "new %d\n", i)
+	}
+	got := unifiedDiff("a/f", "b/f", []byte(old.String()), []byte(new.String()))
+	if want := "Files a/f and b/f differ (too many changes to show)\n"; got != want {
+		t.Errorf("unifiedDiff of different files = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Mrkuib/spx-back/internal/common"
+)
+
+// ErrInvalidParam is wrapped by errors about malformed request
+// parameters.
+var ErrInvalidParam = errors.New("invalid parameter")
+
+// ErrForbidden is returned when a user changes a project they don't
+// own.
+var ErrForbidden = errors.New("forbidden")
+
+// ErrBusy is returned when a project can't be built because as many
+// builds as Config.MaxBuilds allows are already running.
+var ErrBusy = errors.New("too many builds in progress")
+
+// A LimitError reports an uploaded archive that exceeds one of the
+// limits configured in Config.
+type LimitError struct {
+	What  string // the quantity that was limited, e.g. "number of files"
+	Value int
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %v exceeds limit of %v", e.What, e.Value, e.Limit)
+}
+
+// A FileError reports a file of an uploaded archive that can't be
+// accepted, such as one with a bogus or duplicate name.
+type FileError struct {
+	Name   string
+	Reason string
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s %q", e.Reason, e.Name)
+}
+
+// StatusCode maps an error returned by Project to the HTTP status code
+// the API should answer with.
+func StatusCode(err error) int {
+	var limitErr *LimitError
+	var fileErr *FileError
+	var fmtErr *ProjectFormatError
+	var numErr *strconv.NumError // a numeric parameter, such as a page index
+	var rateErr *RateLimitError
+	var quotaErr *QuotaError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &limitErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &rateErr):
+		return http.StatusTooManyRequests
+	case errors.As(err, &quotaErr), errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.As(err, &fmtErr):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &fileErr), errors.As(err, &numErr), errors.Is(err, http.ErrMissingFile),
+		errors.Is(err, ErrUnknownFormat), errors.Is(err, ErrInvalidParam), errors.Is(err, common.ErrInvalidPage):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBusy):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
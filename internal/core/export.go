@@ -0,0 +1,168 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// assetsManifest is the txtar section that lists the binary files of an
+// exported project. It maps each file name to the URL it was uploaded to.
+const assetsManifest = ".assets.json"
+
+const (
+	FormatTxtar = "txtar"
+	FormatZip   = "zip"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// ExportProject returns the stored project as a txtar document or a zip
+// archive, along with its MIME type. In txtar form text files are
+// inlined and the others, including text a section can't hold as it is
+// (see isText), are uploaded to the bucket and referenced from the
+// assetsManifest section, so the result can be pasted and diffed as
+// plain text. The project must be visible to user uid.
+func (p *Project) ExportProject(ctx context.Context, id, uid string, format string) ([]byte, string, error) {
+	c, err := p.FileInfo(ctx, id, uid)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := p.readBlob(ctx, c.Address)
+	if err != nil {
+		return nil, "", err
+	}
+	fs, err := unpackProject(data, p.limits)
+	if err != nil {
+		return nil, "", err
+	}
+	switch format {
+	case FormatZip:
+		out, err := zipFiles(fs)
+		return out, "application/zip", err
+	case FormatTxtar, "":
+		out, err := p.toTxtar(ctx, fs)
+		return out, "text/plain; charset=utf-8", err
+	}
+	return nil, "", ErrUnknownFormat
+}
+
+// ImportProject creates a new project for codeFile.AuthorId from a
+// txtar document produced by ExportProject. Binary files referenced by
+// the assetsManifest section are fetched back from the bucket.
+func (p *Project) ImportProject(ctx context.Context, codeFile *CodeFile, body []byte) (*CodeFile, error) {
+	fs, err := splitFiles(body, p.limits)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.resolveAssets(ctx, fs); err != nil {
+		return nil, err
+	}
+	data, err := zipFiles(fs)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.checkQuota(ctx, codeFile, int64(len(data))); err != nil {
+		return nil, err
+	}
+	codeFile.Size = int64(len(data))
+	codeFile.Thumbnail = p.projectThumbnail(ctx, fs)
+	codeFile.Address, err = UploadReader(ctx, p, p.conf.ProjectPath, codeFile.Name+".zip", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	codeFile.ID, err = AddProject(ctx, p, codeFile)
+	return codeFile, err
+}
+
+// toTxtar formats fs as a txtar document, replacing binary files with
+// references in the assetsManifest section.
+func (p *Project) toTxtar(ctx context.Context, fs *fileSet) ([]byte, error) {
+	out := new(fileSet)
+	assets := make(map[string]string)
+	for _, f := range fs.files {
+		data := fs.Data(f)
+		if isText(data) {
+			out.AddFile(f, data)
+			continue
+		}
+		key, err := p.uploadAsset(ctx, f, data)
+		if err != nil {
+			return nil, err
+		}
+		assets[f] = p.conf.QiniuPath + key
+	}
+	if len(assets) > 0 {
+		manifest, err := json.MarshalIndent(assets, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		out.AddFile(assetsManifest, append(manifest, '\n'))
+	}
+	return out.Format(), nil
+}
+
+// uploadAsset stores a binary project file under a key derived from its
+// content, so exporting the same project twice doesn't duplicate it.
+func (p *Project) uploadAsset(ctx context.Context, name string, data []byte) (string, error) {
+	key := p.assetPrefix() + contentHash(data) + path.Ext(name)
+	if ok, err := p.blobExists(ctx, key); err == nil && ok {
+		return key, nil
+	}
+	return key, p.writeBlob(ctx, key, data)
+}
+
+// assetPrefix is the key prefix uploadAsset stores the binary files of
+// exported projects under.
+func (p *Project) assetPrefix() string {
+	return p.conf.ProjectPath + "assets/"
+}
+
+// resolveAssets replaces the assetsManifest section of fs with the files
+// it references. Only URLs of files uploadAsset stored are accepted, so
+// a manifest can't pull other objects of the bucket, such as another
+// user's bundles, into a project. The resolved files count against the
+// limits on the number and size of the files of a project.
+func (p *Project) resolveAssets(ctx context.Context, fs *fileSet) error {
+	if !fs.Contains(assetsManifest) {
+		return nil
+	}
+	var assets map[string]string
+	if err := json.Unmarshal(fs.Data(assetsManifest), &assets); err != nil {
+		return &FileError{Name: assetsManifest, Reason: err.Error()}
+	}
+	fs.RemoveFile(assetsManifest)
+	if numFiles := fs.Num() + len(assets); numFiles > p.limits.numFiles {
+		return &LimitError{What: "number of files", Value: numFiles, Limit: p.limits.numFiles}
+	}
+	size := 0
+	for _, f := range fs.files {
+		size += len(fs.Data(f))
+	}
+	urlPrefix := p.conf.QiniuPath + p.assetPrefix()
+	for name, url := range assets {
+		if err := checkFileName(name, p.limits); err != nil {
+			return err
+		}
+		if fs.Contains(name) {
+			return &FileError{Name: name, Reason: "duplicate file name"}
+		}
+		hash, ok := strings.CutPrefix(url, urlPrefix)
+		if !ok || hash == "" || strings.Contains(hash, "/") {
+			return &FileError{Name: name, Reason: "foreign asset URL " + url}
+		}
+		data, err := p.readBlob(ctx, p.assetPrefix()+hash)
+		if err != nil {
+			return fmt.Errorf("asset %q: %v", name, err)
+		}
+		if size += len(data); size > p.limits.size {
+			return &LimitError{What: "unpacked size", Value: size, Limit: p.limits.size}
+		}
+		fs.AddFile(name, data)
+	}
+	return nil
+}
@@ -1,8 +1,22 @@
 package core
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/tools/imports"
 )
 
 func ExtractErrorInfo(errorMsg string) FormatError {
@@ -31,4 +45,127 @@ func ExtractErrorInfo(errorMsg string) FormatError {
 	de.Line=lineNumber
 	de.Msg=errorMessage
 	return de
-}
\ No newline at end of file
+}
+
+// fmtTimeout bounds the time CodeFmt may spend on a request.
+const fmtTimeout = 30 * time.Second
+
+// isCodeFile reports whether the file is formatted by CodeFmt and
+// compared line by line by Diff: Go and Go+ code and go.mod.
+func isCodeFile(name string) bool {
+	switch path.Ext(name) {
+	case ".go", ".gop", ".spx", ".gmx":
+		return true
+	}
+	return path.Base(name) == "go.mod"
+}
+
+// formatFile formats a single code file. Go files are processed by
+// imports.Process when fixImports is set; otherwise they, like all Go+
+// files, go through `gop fmt -smart`. The error, if any, is in the
+// "file:line:col: msg" form understood by ExtractErrorInfo.
+func formatFile(ctx context.Context, f string, in []byte, fixImports bool) ([]byte, error) {
+	if path.Base(f) == "go.mod" {
+		return formatGoMod(f, in)
+	}
+	if fixImports && path.Ext(f) == ".go" {
+		// TODO: pass options to imports.Process so it
+		// can find symbols in sibling files.
+		return imports.Process(f, in, nil)
+	}
+	tmpDir, err := os.MkdirTemp("", "gopformat")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	// gop fmt picks the syntax from the extension, and Go code is
+	// formatted as Go+.
+	tmpName := "prog.gop"
+	if ext := path.Ext(f); ext != ".go" {
+		tmpName = "prog" + ext
+	}
+	tmpGopFile := filepath.Join(tmpDir, tmpName)
+	if err = os.WriteFile(tmpGopFile, in, 0644); err != nil {
+		return nil, err
+	}
+	ctx, span := tracer.Start(ctx, "gop fmt", trace.WithAttributes(attribute.String("file", f)))
+	cmd := exec.CommandContext(ctx, "gop", "fmt", "-smart", tmpGopFile)
+	//gop fmt returns error result in stdout, so we do not need to handle stderr
+	//err is to check gop fmt return code
+	fmtErr, err := cmd.Output()
+	endSpan(span, err)
+	if err != nil {
+		msg := string(fmtErr)
+		if strings.TrimSpace(msg) == "" {
+			// gop didn't report why, e.g. it was killed on timeout
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			msg = fmt.Sprintf("%s: gop fmt: %v", tmpGopFile, err)
+		}
+		return nil, errors.New(strings.Replace(msg, tmpGopFile, f, -1))
+	}
+	out, err := os.ReadFile(tmpGopFile)
+	if err != nil {
+		return nil, errors.New("interval error when formatting gop code")
+	}
+	return out, nil
+}
+
+// A ProjectFormatError reports the code files of a project that could
+// not be formatted, keyed by file name.
+type ProjectFormatError struct {
+	Files map[string]FormatError
+}
+
+func (e *ProjectFormatError) Error() string {
+	names := make([]string, 0, len(e.Files))
+	for name := range e.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		fe := e.Files[name]
+		msgs[i] = fmt.Sprintf("%s:%d:%d: %s", name, fe.Line, fe.Column, fe.Msg)
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// formatProject runs every code file of a project bundle through
+// formatFile and returns the bundle repacked in its original format,
+// zip archives under their original top-level directory. If any file
+// fails, a *ProjectFormatError lists all failures.
+func (p *Project) formatProject(ctx context.Context, data []byte) ([]byte, error) {
+	fs, err := unpackProject(data, p.limits)
+	if err != nil {
+		return nil, err
+	}
+	diags := make(map[string]FormatError)
+	for _, f := range fs.files {
+		if !isCodeFile(f) {
+			continue
+		}
+		out, err := formatFile(ctx, f, fs.Data(f), false)
+		if err != nil {
+			diags[f] = ExtractErrorInfo(err.Error())
+			continue
+		}
+		fs.AddFile(f, out)
+	}
+	if len(diags) > 0 {
+		return nil, &ProjectFormatError{Files: diags}
+	}
+	if bytes.HasPrefix(data, zipMagic) {
+		prefix, err := zipPrefix(data)
+		if err != nil {
+			return nil, err
+		}
+		under := new(fileSet)
+		for _, f := range fs.files {
+			under.AddFile(prefix+f, fs.Data(f))
+		}
+		return zipFiles(under)
+	}
+	return fs.Format(), nil
+}
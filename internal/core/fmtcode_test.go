@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestExtractErrorInfo(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want FormatError
+	}{
+		{
+			msg:  "main.spx:3:5: expected ';', found 'IDENT' x",
+			want: FormatError{Line: 3, Column: 5, Msg: "expected ';', found 'IDENT' x"},
+		},
+		{
+			msg:  "/tmp/gopfmt123/a/b.go:12:1: expected declaration (and 2 more errors)",
+			want: FormatError{Line: 12, Column: 1, Msg: "expected declaration "},
+		},
+		{
+			msg:  "prog.go:1:9: missing package name\nprog.go:2:1: another",
+			want: FormatError{Line: 1, Column: 9, Msg: "missing package name"},
+		},
+		{
+			msg:  "exit status 2",
+			want: FormatError{Msg: "exit status 2"},
+		},
+		{
+			msg:  "main.spx:99999999999999999999:1: overflow",
+			want: FormatError{Msg: "main.spx:99999999999999999999:1: overflow"},
+		},
+	}
+	for _, tt := range tests {
+		if got := ExtractErrorInfo(tt.msg); got != tt.want {
+			t.Errorf("ExtractErrorInfo(%q) = %+v, want %+v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestFormatProjectKeepsPrefix(t *testing.T) {
+	fs := new(fileSet)
+	fs.AddFile("demo/go.mod", []byte("module  demo\n"))
+	fs.AddFile("demo/assets/index.json", []byte("{ }"))
+	data, err := zipFiles(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Project{limits: testLimits}
+	out, err := p.formatProject(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix, err := zipPrefix(out); err != nil || prefix != "demo/" {
+		t.Errorf("prefix = %q, %v, want demo/", prefix, err)
+	}
+	got, err := unzipFiles(out, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod, index := string(got.Data("go.mod")), string(got.Data("assets/index.json")); mod != "module demo\n" || index != "{ }" {
+		t.Errorf("formatted go.mod = %q, assets/index.json = %q", mod, index)
+	}
+}
+
+func TestFormatProjectSpx(t *testing.T) {
+	src := "onStart  =>  {\n}\n"
+	fs := new(fileSet)
+	fs.AddFile("main.spx", []byte(src))
+	p := &Project{limits: testLimits}
+	out, err := p.formatProject(context.Background(), fs.Format())
+	if _, lookErr := exec.LookPath("gop"); lookErr != nil {
+		// without the toolchain the failure must still be explained
+		var fmtErr *ProjectFormatError
+		if !errors.As(err, &fmtErr) || fmtErr.Files["main.spx"].Msg == "" {
+			t.Errorf("formatProject without gop = %v, want a diagnostic for main.spx", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := splitFiles(out, testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spx := string(got.Data("main.spx")); spx == src {
+		t.Errorf("main.spx left unformatted: %q", spx)
+	}
+}
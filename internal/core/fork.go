@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxForkTree bounds the number of projects ForkTree returns, so a
+// popular project can't make it scan the whole table.
+const maxForkTree = 1000
+
+// A ForkNode is a project of a remix tree, along with the projects
+// forked from it.
+type ForkNode struct {
+	ID       string      `json:"id"`
+	Name     string      `json:"name"`
+	AuthorId string      `json:"authorId"`
+	CTime    time.Time   `json:"cTime"`
+	Forks    []*ForkNode `json:"forks"`
+}
+
+// Fork copies project id for user uid. The bundle is copied inside the
+// bucket, and the new project records id in ForkedFrom and starts with
+// a revision of its own. The copy counts against the quota of uid. Forks
+// of private projects are private, others are unlisted.
+func (p *Project) Fork(ctx context.Context, id, uid string) (*CodeFile, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	src, err := p.FileInfo(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	visibility := VisibilityUnlisted
+	if src.Visibility == VisibilityPrivate {
+		visibility = VisibilityPrivate
+	}
+	return p.fork(ctx, src, uid, visibility)
+}
+
+// fork copies project src for user uid, whether uid sees it or not, into
+// a project with the given visibility.
+func (p *Project) fork(ctx context.Context, src *CodeFile, uid, visibility string) (*CodeFile, error) {
+	fork := &CodeFile{
+		Name:       src.Name,
+		AuthorId:   uid,
+		Size:       src.Size,
+		ForkedFrom: src.ID,
+		Visibility: visibility,
+		Thumbnail:  src.Thumbnail,
+	}
+	err := p.checkQuota(ctx, fork, fork.Size)
+	if err != nil {
+		return nil, err
+	}
+	fork.Address = newBlobKey(p.conf.ProjectPath, src.Name+path.Ext(src.Address))
+	if err = p.copyBlob(ctx, fork.Address, src.Address); err != nil {
+		return nil, err
+	}
+	if fork.ID, err = AddProject(ctx, p, fork); err != nil {
+		return nil, err
+	}
+	if _, err = AddRevision(ctx, p, fork.ID, fork.Address, fork.Size); err != nil {
+		return nil, err
+	}
+	return fork, nil
+}
+
+// ForkTree returns the remix tree rooted at project id: the projects
+// forked from it, the ones forked from those, and so on, oldest first.
+// Private projects of other users than uid are left out, along with
+// their forks. Past maxForkTree projects the tree is cut short.
+func (p *Project) ForkTree(ctx context.Context, id, uid string) (*ForkNode, error) {
+	tree := &ForkNode{Forks: []*ForkNode{}}
+	var visibility string
+	query := "SELECT id, name, author_id, visibility, c_time FROM project WHERE id = ?"
+	err := p.db.QueryRowContext(ctx, query, id).Scan(&tree.ID, &tree.Name, &tree.AuthorId, &visibility, &tree.CTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !(&CodeFile{AuthorId: tree.AuthorId, Visibility: visibility}).visibleTo(uid) {
+		return nil, ErrNotExist
+	}
+	level := map[string]*ForkNode{tree.ID: tree}
+	for n := 1; len(level) > 0 && n < maxForkTree; {
+		ids := make([]interface{}, 0, len(level))
+		for id := range level {
+			ids = append(ids, id)
+		}
+		query = "SELECT id, name, author_id, c_time, forked_from FROM project WHERE forked_from IN (?" +
+			strings.Repeat(", ?", len(ids)-1) + ") AND (visibility != ? OR author_id = ?) ORDER BY id LIMIT ?"
+		rows, err := p.db.QueryContext(ctx, query, append(ids, VisibilityPrivate, uid, maxForkTree-n)...)
+		if err != nil {
+			return nil, err
+		}
+		next := make(map[string]*ForkNode)
+		for rows.Next() {
+			node := &ForkNode{Forks: []*ForkNode{}}
+			var parent string
+			if err := rows.Scan(&node.ID, &node.Name, &node.AuthorId, &node.CTime, &parent); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			level[parent].Forks = append(level[parent].Forks, node)
+			next[node.ID] = node
+			n++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		level = next
+	}
+	return tree, nil
+}
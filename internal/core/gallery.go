@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Mrkuib/spx-back/internal/common"
+)
+
+// Sort orders of the gallery.
+const (
+	SortRecent   = "recent"   // newest first
+	SortPopular  = "popular"  // most liked, then most viewed, first
+	SortTrending = "trending" // highest trending score first, see RefreshTrending
+)
+
+var galleryOrders = map[string]string{
+	SortRecent:   "c_time DESC, id DESC",
+	SortPopular:  "like_count DESC, view_count DESC, id DESC",
+	SortTrending: "trending_score DESC, id DESC",
+}
+
+// likeWeight is how many views a like is worth in the trending score.
+const likeWeight = 3
+
+// trendingGravity is how fast the trending score of a project falls with
+// its age.
+const trendingGravity = 1.5
+
+// A GalleryProject is a public project as listed in the gallery.
+type GalleryProject struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	AuthorId      string    `json:"authorId"`
+	Address       string    `json:"address"`
+	ForkedFrom    *string   `json:"forkedFrom,omitempty"`
+	ViewCount     int64     `json:"viewCount"`
+	LikeCount     int64     `json:"likeCount"`
+	TrendingScore float64   `json:"trendingScore"`
+	Thumbnail     string    `json:"thumbnailUrl,omitempty"` // a key until Gallery turns it into a URL
+	CTime         time.Time `json:"cTime"`
+	UTime         time.Time `json:"uTime"`
+}
+
+func (GalleryProject) TableName() string { return "project" }
+
+// Gallery lists the public projects in the given sort order, recent if
+// empty. Like RefreshTrending, it skips deleted projects, whose status
+// is 0: common.QueryByPage adds that condition to every query.
+func (p *Project) Gallery(ctx context.Context, sort, pageIndex, pageSize string) (*common.Pagination[GalleryProject], error) {
+	if sort == "" {
+		sort = SortRecent
+	}
+	orderBy, ok := galleryOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: sort %q is not recent, popular or trending", ErrInvalidParam, sort)
+	}
+	wheres := []common.FilterCondition{
+		{Column: "visibility", Operation: "=", Value: VisibilityPublic},
+	}
+	page, err := common.QueryByPage[GalleryProject](ctx, p.db, pageIndex, pageSize, wheres, orderBy)
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Data {
+		page.Data[i].Address = p.conf.QiniuPath + page.Data[i].Address
+		page.Data[i].Thumbnail = p.thumbnailURL(page.Data[i].Thumbnail)
+	}
+	return page, nil
+}
+
+// trendingScore is the score of a project with the given views and likes
+// at age: its activity divided by a power of its age in hours, so new
+// projects need less activity to trend and old ones fade away.
+func trendingScore(views, likes int64, age time.Duration) float64 {
+	hours := math.Max(age.Hours(), 0)
+	return float64(views+likeWeight*likes) / math.Pow(hours+2, trendingGravity)
+}
+
+// RefreshTrending recomputes the trending score of every public project.
+func (p *Project) RefreshTrending(ctx context.Context) error {
+	query := "SELECT id, view_count, like_count, c_time FROM project WHERE visibility = ? AND status != 0"
+	rows, err := p.db.QueryContext(ctx, query, VisibilityPublic)
+	if err != nil {
+		return err
+	}
+	type score struct {
+		id    string
+		value float64
+	}
+	var scores []score
+	now := time.Now()
+	for rows.Next() {
+		var s score
+		var views, likes int64
+		var ctime time.Time
+		if err := rows.Scan(&s.id, &views, &likes, &ctime); err != nil {
+			rows.Close()
+			return err
+		}
+		s.value = trendingScore(views, likes, now.Sub(ctime))
+		scores = append(scores, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "UPDATE project SET trending_score = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, s := range scores {
+		if _, err := stmt.ExecContext(ctx, s.value, s.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// refreshTrending runs RefreshTrending every Config.TrendingInterval
+// until ctx is done.
+func (p *Project) refreshTrending(ctx context.Context) {
+	ticker := time.NewTicker(p.conf.TrendingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.RefreshTrending(ctx); err != nil && ctx.Err() == nil {
+				p.log.ErrorContext(ctx, "refreshing trending scores failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Mrkuib/spx-back/internal/common"
+)
+
+// Sort orders of AssetList, besides the order of the table.
+const SortViews = "views" // most viewed first
+
+var assetOrders = map[string]string{
+	"":          "",
+	SortRecent:  "c_time DESC, id DESC",
+	SortPopular: "favorite_count DESC, view_count DESC, id DESC",
+	SortViews:   "view_count DESC, id DESC",
+}
+
+// A LikeState is whether a user likes a project, and how many users do,
+// see mark.
+type LikeState struct {
+	Liked     bool  `json:"liked"`
+	LikeCount int64 `json:"likeCount"`
+}
+
+// A FavoriteState is whether a user has an asset among their favorites,
+// and how many users do, see mark.
+type FavoriteState struct {
+	Favorited     bool  `json:"favorited"`
+	FavoriteCount int64 `json:"favoriteCount"`
+}
+
+// A mark is a relation between users and the rows of a table, such as
+// likes between users and projects, along with the column of the table
+// counting it. Each mark records the client IP it was set from, and the
+// counter counts distinct IPs rather than users: anyone can claim to be
+// any user, so made up user ids from one address count once.
+type mark struct {
+	table   string // holds a row per user and target
+	key     string // column of table referring to the target
+	target  string
+	counter string // column of target counting the rows of table
+}
+
+var (
+	projectLikes   = mark{table: "project_like", key: "project_id", target: "project", counter: "like_count"}
+	assetFavorites = mark{table: "asset_favorite", key: "asset_id", target: "asset", counter: "favorite_count"}
+	commentReports = mark{table: "comment_report", key: "comment_id", target: "comment", counter: "report_count"}
+)
+
+// set adds the mark of user uid at ip on target id, or removes it if on
+// is false, and returns the updated count. Setting a mark twice, or
+// removing one that isn't there, only returns the count. The counter is
+// recounted in the transaction that changed the marks.
+func (m mark) set(ctx context.Context, p *Project, id, uid, ip string, on bool) (count int64, err error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var res sql.Result
+	if on {
+		query := fmt.Sprintf("%s INTO %s (%s, user_id, client_ip, c_time) VALUES (?, ?, ?, ?)", p.insertIgnore(), m.table, m.key)
+		res, err = tx.ExecContext(ctx, query, id, uid, ip, time.Now())
+	} else {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND user_id = ?", m.table, m.key)
+		res, err = tx.ExecContext(ctx, query, id, uid)
+	}
+	if err != nil {
+		return 0, err
+	}
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if changed != 0 {
+		query := fmt.Sprintf("UPDATE %s SET %s = (SELECT COUNT(DISTINCT client_ip) FROM %s WHERE %s = ?) WHERE id = ?",
+			m.target, m.counter, m.table, m.key)
+		if _, err = tx.ExecContext(ctx, query, id, id); err != nil {
+			return 0, err
+		}
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", m.counter, m.target)
+	if err = tx.QueryRowContext(ctx, query, id).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// insertIgnore starts an INSERT that skips rows duplicating a key, in
+// the dialect of Config.Driver.
+func (p *Project) insertIgnore() string {
+	if p.conf.Driver == "sqlite3" {
+		return "INSERT OR IGNORE"
+	}
+	return "INSERT IGNORE"
+}
+
+// LikeProject records that user uid at ip likes project id, or no longer
+// does if like is false. The project must be visible to uid.
+func (p *Project) LikeProject(ctx context.Context, id, uid, ip string, like bool) (*LikeState, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	if _, err := p.FileInfo(ctx, id, uid); err != nil {
+		return nil, err
+	}
+	count, err := projectLikes.set(ctx, p, id, uid, ip, like)
+	if err != nil {
+		return nil, err
+	}
+	return &LikeState{Liked: like, LikeCount: count}, nil
+}
+
+// FavoriteAsset adds asset id to the favorites of user uid at ip, or
+// removes it if favorite is false.
+func (p *Project) FavoriteAsset(ctx context.Context, id, uid, ip string, favorite bool) (*FavoriteState, error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	if _, err := p.Asset(ctx, id); err != nil {
+		return nil, err
+	}
+	count, err := assetFavorites.set(ctx, p, id, uid, ip, favorite)
+	if err != nil {
+		return nil, err
+	}
+	return &FavoriteState{Favorited: favorite, FavoriteCount: count}, nil
+}
+
+// Favorites lists the assets user uid added to their favorites, last
+// added first. Deleted assets are left out.
+func (p *Project) Favorites(ctx context.Context, uid, pageIndex, pageSize string) (*common.Pagination[AssetResponse], error) {
+	if uid == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	index, size, err := common.ParsePage(pageIndex, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	const from = " FROM asset_favorite f JOIN asset a ON a.id = f.asset_id WHERE f.user_id = ? AND a.status != 0"
+	page := &common.Pagination[Asset]{}
+	if err = p.db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, uid).Scan(&page.TotalCount); err != nil {
+		return nil, err
+	}
+	page.TotalPage = (page.TotalCount + size - 1) / size
+	query := "SELECT a.id, a.name, a.author_id, a.category, a.is_public, a.address, a.asset_type, a.status, " +
+		"a.view_count, a.favorite_count, a.thumbnail, a.duration, a.sample_rate, a.channels, a.c_time, a.u_time" + from + " ORDER BY f.c_time DESC, a.id DESC LIMIT ?, ?"
+	rows, err := p.db.QueryContext(ctx, query, uid, (index-1)*size, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Asset
+		err := rows.Scan(&a.ID, &a.Name, &a.AuthorId, &a.Category, &a.IsPublic, &a.Address, &a.AssetType, &a.Status,
+			&a.ViewCount, &a.FavoriteCount, &a.Thumbnail, &a.Duration, &a.SampleRate, &a.Channels, &a.CTime, &a.UTime)
+		if err != nil {
+			return nil, err
+		}
+		page.Data = append(page.Data, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return p.assetPage(page)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID. An ID sent by the client, or
+// by a proxy in front of the service, is kept; otherwise one is made up.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds the length of request IDs taken from clients.
+const maxRequestIDLen = 64
+
+func newLogger(conf *Config) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if conf.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// requestInfo is what LogRequests knows about a request. It is stored in
+// the request context so errors can be logged along with it.
+type requestInfo struct {
+	log *slog.Logger // carries the request ID and route
+	req *http.Request
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	ri, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return ri
+}
+
+// user returns the ID of the user making the request, taken from the
+// X-User-Id header or the uid parameter.
+func (ri *requestInfo) user() string {
+	if uid := ri.req.Header.Get("X-User-Id"); uid != "" {
+		return uid
+	}
+	return ri.param("uid")
+}
+
+// project returns the ID of the project the request is about, if any.
+func (ri *requestInfo) project() string {
+	return ri.param("id")
+}
+
+// param returns the value of the request parameter name. yap stores path
+// parameters in the form, so once the handler has run both path and
+// form parameters are found there.
+func (ri *requestInfo) param(name string) string {
+	if ri.req.Form != nil {
+		return ri.req.Form.Get(name)
+	}
+	return ri.req.URL.Query().Get(name)
+}
+
+// LogRequests wraps h to give every request an ID, a trace span and a
+// log line. The ID is sent back in the X-Request-Id header.
+func (p *Project) LogRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLen {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		route := routePattern(r.URL.Path)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("request.id", id),
+			))
+		defer span.End()
+
+		log := p.log.With("request_id", id, "method", r.Method, "route", route)
+		if sc := span.SpanContext(); sc.IsValid() {
+			log = log.With("trace_id", sc.TraceID().String())
+		}
+		ri := &requestInfo{log: log}
+		r = r.WithContext(context.WithValue(ctx, requestInfoKey{}, ri))
+		ri.req = r
+
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.code))
+		if sw.code >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(sw.code))
+		}
+		log.Info("request",
+			"path", r.URL.Path,
+			"status", sw.code,
+			"duration", time.Since(start),
+			"user", ri.user(),
+			"project", ri.project(),
+		)
+	})
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ErrorStatus logs err, which a handler is about to answer with, along
+// with the route, user and project of the request in ctx, and returns
+// the HTTP status code to answer with. See StatusCode.
+func ErrorStatus(ctx context.Context, err error) int {
+	code := StatusCode(err)
+	level := slog.LevelWarn
+	if code >= 500 {
+		level = slog.LevelError
+	}
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	if ri := requestInfoFrom(ctx); ri != nil {
+		ri.log.Log(ctx, level, "request failed", "err", err, "status", code, "user", ri.user(), "project", ri.project())
+	} else {
+		slog.Log(ctx, level, "request failed", "err", err, "status", code)
+	}
+	return code
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxRouteLabels caps the number of distinct route labels, so clients
+// requesting odd paths can't blow up the number of series.
+const maxRouteLabels = 200
+
+// metrics holds the Prometheus collectors of a Project. Each Project has
+// its own registry so several of them can live in one process.
+type metrics struct {
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+	uploadBytes prometheus.Counter
+	fmtDuration prometheus.Histogram
+	fmtFailures prometheus.Counter
+
+	mu     sync.Mutex
+	routes map[string]bool
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spx_http_requests_total",
+			Help: "Number of HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spx_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		uploadBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spx_upload_bytes_total",
+			Help: "Number of bytes uploaded to the bucket.",
+		}),
+		fmtDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "spx_codefmt_duration_seconds",
+			Help:    "Time spent formatting code in CodeFmt.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		fmtFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spx_codefmt_failures_total",
+			Help: "Number of CodeFmt requests whose code failed to format.",
+		}),
+		routes: make(map[string]bool),
+	}
+	m.registry.MustRegister(
+		m.requests, m.latency, m.uploadBytes, m.fmtDuration, m.fmtFailures,
+		collectors.NewDBStatsCollector(db, "spx"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// MetricsHandler serves the metrics of p in the Prometheus text format.
+func (p *Project) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(p.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument wraps h to count requests and measure their latency.
+func (p *Project) Instrument(h http.Handler) http.Handler {
+	m := p.metrics
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		route := m.routeLabel(r.URL.Path, sw.code)
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(sw.code)).Inc()
+		m.latency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeLabel derives the route label of a request path, see
+// routePattern.
+func (m *metrics) routeLabel(path string, code int) string {
+	if code == http.StatusNotFound {
+		return "unmatched"
+	}
+	route := routePattern(path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.routes[route] {
+		if len(m.routes) >= maxRouteLabels {
+			return "other"
+		}
+		m.routes[route] = true
+	}
+	return route
+}
+
+// routePattern guesses the route pattern of a request path. yap doesn't
+// tell which pattern matched, so segments that look like parameters are
+// replaced by ":param". Literal segments are lowercase words, possibly
+// with dots or dashes, and API versions such as v1.
+func routePattern(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if !isLiteralSegment(part) {
+			parts[i] = ":param"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isLiteralSegment(s string) bool {
+	if len(s) > 1 && s[0] == 'v' && strings.Trim(s[1:], "0123456789") == "" {
+		return true
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return (r < 'a' || r > 'z') && r != '.' && r != '-' && r != '_'
+	}) == -1
+}
+
+// statusWriter records the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,449 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mrkuib/spx-back/internal/common"
+)
+
+// APIVersion is the version of the /api/v1 surface described by
+// OpenAPI.
+const APIVersion = "1.0.0"
+
+// apiSchemas are the types listed in the components of the OpenAPI
+// document, by name. Their schemas are derived from the Go types the
+// way encoding/json would marshal them, so they can't drift apart.
+var apiSchemas = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Asset", reflect.TypeOf(AssetResponse{})},
+	{"AssetAddress", reflect.TypeOf(AssetAddress{})},
+	{"SpriteAtlas", reflect.TypeOf(SpriteAtlas{})},
+	{"AtlasFrame", reflect.TypeOf(AtlasFrame{})},
+	{"CodeFile", reflect.TypeOf(CodeFile{})},
+	{"Pagination", reflect.TypeOf(common.Pagination[AssetResponse]{})},
+	{"FormatError", reflect.TypeOf(FormatError{})},
+	{"FormatResponse", reflect.TypeOf(FormatResponse{})},
+	{"BuildResponse", reflect.TypeOf(BuildResponse{})},
+	{"Revision", reflect.TypeOf(Revision{})},
+	{"FileDiff", reflect.TypeOf(FileDiff{})},
+	{"AssetChange", reflect.TypeOf(AssetChange{})},
+	{"ProjectDiff", reflect.TypeOf(ProjectDiff{})},
+	{"ForkNode", reflect.TypeOf(ForkNode{})},
+	{"Share", reflect.TypeOf(Share{})},
+	{"GalleryProject", reflect.TypeOf(GalleryProject{})},
+	{"GalleryPage", reflect.TypeOf(common.Pagination[GalleryProject]{})},
+	{"LikeState", reflect.TypeOf(LikeState{})},
+	{"FavoriteState", reflect.TypeOf(FavoriteState{})},
+	{"Comment", reflect.TypeOf(Comment{})},
+	{"Class", reflect.TypeOf(Class{})},
+	{"Assignment", reflect.TypeOf(Assignment{})},
+	{"Submission", reflect.TypeOf(Submission{})},
+	{"UnsupportedBlock", reflect.TypeOf(UnsupportedBlock{})},
+	{"Sb3Import", reflect.TypeOf(Sb3Import{})},
+}
+
+// An apiParam is a path or query parameter, or a form field, of an
+// operation.
+type apiParam struct {
+	name, in, typ, desc string
+}
+
+// An apiOp is an operation of the /api/v1 surface. data names the
+// schema of the data field of the response envelope, or is a raw media
+// type for operations that don't answer JSON. Without data the envelope
+// carries no data.
+type apiOp struct {
+	method, path, id, summary string
+	params                    []apiParam
+	form                      []apiParam // multipart/form-data fields
+	data                      string
+	list                      bool // data is an array of data
+}
+
+var (
+	projectID = apiParam{"id", "path", "string", "project ID"}
+	assetID   = apiParam{"id", "path", "string", "asset ID"}
+	commentID = apiParam{"id", "path", "string", "comment ID"}
+	classID   = apiParam{"id", "path", "string", "class ID"}
+	assignID  = apiParam{"id", "path", "string", "assignment ID"}
+)
+
+// userID identifies the caller, who must own the project to change it
+// and to see it if it's private. The uid form field works too.
+var userID = apiParam{"X-User-Id", "header", "string", "ID of the user making the request"}
+
+var apiOps = []apiOp{
+	{method: "POST", path: "/projects", id: "createProject", summary: "Create a project from an uploaded bundle",
+		form: []apiParam{
+			{"file", "form", "binary", "project bundle, a zip archive or a txtar document"},
+			{"name", "form", "string", "project name"},
+			{"uid", "form", "string", "author ID"},
+			{"format", "form", "string", "1 to format the code files first"},
+			{"visibility", "form", "string", "private, unlisted (default) or public"},
+		},
+		data: "CodeFile"},
+	{method: "GET", path: "/projects/{id}", id: "getProject", summary: "Get a project",
+		params: []apiParam{projectID, userID}, data: "CodeFile"},
+	{method: "PUT", path: "/projects/{id}", id: "updateProject", summary: "Save a new version of a project",
+		params: []apiParam{projectID},
+		form: []apiParam{
+			{"file", "form", "binary", "project bundle, a zip archive or a txtar document"},
+			{"name", "form", "string", "project name"},
+			{"format", "form", "string", "1 to format the code files first"},
+			{"visibility", "form", "string", "private, unlisted or public, unchanged if empty"},
+		},
+		data: "CodeFile"},
+	{method: "POST", path: "/projects/{id}/build", id: "buildProject", summary: "Build a project to WebAssembly",
+		params: []apiParam{projectID, userID}, data: "BuildResponse"},
+	{method: "GET", path: "/projects/{id}/export", id: "exportProject", summary: "Export a project",
+		params: []apiParam{projectID, userID, {"format", "query", "string", "txtar (default) or zip"}},
+		data:   "application/octet-stream"},
+	{method: "GET", path: "/projects/{id}/revisions", id: "listRevisions", summary: "List the revisions of a project, newest first",
+		params: []apiParam{projectID, userID}, data: "Revision", list: true},
+	{method: "GET", path: "/projects/{id}/diff", id: "diffProject", summary: "Compare two revisions of a project",
+		params: []apiParam{projectID, userID,
+			{"from", "query", "string", "revision ID"},
+			{"to", "query", "string", "revision ID, the current version if empty"},
+		},
+		data: "ProjectDiff"},
+	{method: "POST", path: "/projects/{id}/forks", id: "forkProject", summary: "Fork a project into a new project of the caller",
+		params: []apiParam{projectID},
+		form:   []apiParam{{"uid", "form", "string", "ID of the user forking the project"}},
+		data:   "CodeFile"},
+	{method: "GET", path: "/projects/{id}/forks", id: "listForks", summary: "Get the remix tree of a project",
+		params: []apiParam{projectID, userID}, data: "ForkNode"},
+	{method: "PUT", path: "/projects/{id}/visibility", id: "setVisibility", summary: "Change the visibility of a project",
+		params: []apiParam{projectID, userID},
+		form:   []apiParam{{"visibility", "form", "string", "private, unlisted or public"}},
+		data:   "CodeFile"},
+	{method: "POST", path: "/projects/{id}/share", id: "shareProject", summary: "Get the share link of a project, making one if needed",
+		params: []apiParam{projectID, userID}, data: "Share"},
+	{method: "DELETE", path: "/projects/{id}/share", id: "unshareProject", summary: "Revoke the share link of a project",
+		params: []apiParam{projectID, userID}},
+	{method: "POST", path: "/projects/{id}/like", id: "likeProject", summary: "Like a project",
+		params: []apiParam{projectID, userID}, data: "LikeState"},
+	{method: "DELETE", path: "/projects/{id}/like", id: "unlikeProject", summary: "Take back the like of a project",
+		params: []apiParam{projectID, userID}, data: "LikeState"},
+	{method: "GET", path: "/projects/{id}/comments", id: "listComments", summary: "List the comments on a project as threads, oldest first",
+		params: []apiParam{projectID, userID}, data: "Comment", list: true},
+	{method: "POST", path: "/projects/{id}/comments", id: "addComment", summary: "Comment on a project",
+		params: []apiParam{projectID, userID},
+		form: []apiParam{
+			{"body", "form", "string", "comment text"},
+			{"parentId", "form", "string", "ID of the comment replied to, if any"},
+		},
+		data: "Comment"},
+	{method: "PUT", path: "/comments/{id}", id: "editComment", summary: "Edit a comment of the caller",
+		params: []apiParam{commentID, userID},
+		form:   []apiParam{{"body", "form", "string", "comment text"}},
+		data:   "Comment"},
+	{method: "DELETE", path: "/comments/{id}", id: "deleteComment", summary: "Delete a comment of the caller or on a project of the caller",
+		params: []apiParam{commentID, userID}},
+	{method: "POST", path: "/comments/{id}/report", id: "reportComment", summary: "Report a comment",
+		params: []apiParam{commentID, userID},
+		form:   []apiParam{{"reason", "form", "string", "why the comment is reported, optional"}}},
+	{method: "GET", path: "/shares/{token}", id: "getSharedProject", summary: "Get a project by its share token, whatever its visibility",
+		params: []apiParam{{"token", "path", "string", "share token"}}, data: "CodeFile"},
+	{method: "POST", path: "/imports", id: "importProject", summary: "Create a project from a txtar document made by exportProject",
+		form: []apiParam{
+			{"body", "form", "string", "txtar document"},
+			{"name", "form", "string", "project name"},
+			{"uid", "form", "string", "author ID"},
+		},
+		data: "CodeFile"},
+	{method: "POST", path: "/imports/sb3", id: "importSb3", summary: "Create a project from a Scratch 3 project",
+		params: []apiParam{userID},
+		form: []apiParam{
+			{"file", "form", "binary", "sb3 archive"},
+			{"name", "form", "string", "project name, the file name if empty"},
+			{"visibility", "form", "string", "private, unlisted (default) or public"},
+		},
+		data: "Sb3Import"},
+	{method: "GET", path: "/assets", id: "listAssets", summary: "List assets",
+		params: []apiParam{
+			{"type", "query", "string", "asset type"},
+			{"sort", "query", "string", "recent, popular or views, the order of the table if empty"},
+			{"page", "query", "integer", "page index, from 1"},
+			{"pageSize", "query", "integer", "page size"},
+		},
+		data: "Pagination"},
+	{method: "POST", path: "/assets", id: "createAsset", summary: "Upload an asset of the caller",
+		params: []apiParam{userID},
+		form: []apiParam{
+			{"file", "form", "binary", "a file of the asset, repeated for each; a sprite has an index.json and its costumes, a sound is a single wav, mp3 or ogg file"},
+			{"name", "form", "string", "asset name"},
+			{"assetType", "form", "string", "asset type, such as sprite, backdrop or sound"},
+			{"category", "form", "string", "category"},
+			{"isPublic", "form", "string", "1 to make the asset public"},
+		},
+		data: "Asset"},
+	{method: "GET", path: "/assets/{id}", id: "getAsset", summary: "Get an asset",
+		params: []apiParam{assetID, userID}, data: "Asset"},
+	{method: "POST", path: "/assets/{id}/favorite", id: "favoriteAsset", summary: "Add an asset to the favorites of the caller",
+		params: []apiParam{assetID, userID}, data: "FavoriteState"},
+	{method: "DELETE", path: "/assets/{id}/favorite", id: "unfavoriteAsset", summary: "Remove an asset from the favorites of the caller",
+		params: []apiParam{assetID, userID}, data: "FavoriteState"},
+	{method: "GET", path: "/favorites", id: "listFavorites", summary: "List the favorite assets of the caller, last added first",
+		params: []apiParam{
+			userID,
+			{"page", "query", "integer", "page index, from 1"},
+			{"pageSize", "query", "integer", "page size"},
+		},
+		data: "Pagination"},
+	{method: "GET", path: "/gallery", id: "listGallery", summary: "List public projects",
+		params: []apiParam{
+			{"sort", "query", "string", "recent (default), popular or trending"},
+			{"page", "query", "integer", "page index, from 1"},
+			{"pageSize", "query", "integer", "page size"},
+		},
+		data: "GalleryPage"},
+	{method: "POST", path: "/classes", id: "createClass", summary: "Create a class taught by the caller",
+		params: []apiParam{userID},
+		form:   []apiParam{{"name", "form", "string", "class name"}},
+		data:   "Class"},
+	{method: "GET", path: "/classes", id: "listClasses", summary: "List the classes the caller teaches or attends, newest first",
+		params: []apiParam{userID}, data: "Class", list: true},
+	{method: "GET", path: "/classes/{id}", id: "getClass", summary: "Get a class",
+		params: []apiParam{classID, userID}, data: "Class"},
+	{method: "POST", path: "/enrollments", id: "joinClass", summary: "Join a class by its join code",
+		params: []apiParam{userID},
+		form:   []apiParam{{"code", "form", "string", "join code of the class"}},
+		data:   "Class"},
+	{method: "DELETE", path: "/classes/{id}/students/{student}", id: "removeStudent", summary: "Remove a student from a class, or leave it",
+		params: []apiParam{classID, {"student", "path", "string", "student ID"}, userID}},
+	{method: "POST", path: "/classes/{id}/assignments", id: "createAssignment", summary: "Add an assignment to a class",
+		params: []apiParam{classID, userID},
+		form: []apiParam{
+			{"title", "form", "string", "assignment title"},
+			{"description", "form", "string", "assignment description"},
+			{"starterId", "form", "string", "ID of the project students fork"},
+			{"due", "form", "string", "RFC 3339 deadline, optional"},
+		},
+		data: "Assignment"},
+	{method: "GET", path: "/classes/{id}/assignments", id: "listAssignments", summary: "List the assignments of a class, newest first",
+		params: []apiParam{classID, userID}, data: "Assignment", list: true},
+	{method: "POST", path: "/assignments/{id}/accept", id: "acceptAssignment", summary: "Get the caller's fork of the starter project, making it if needed",
+		params: []apiParam{assignID, userID}, data: "CodeFile"},
+	{method: "GET", path: "/assignments/{id}/submissions", id: "listSubmissions", summary: "List the project of each student of the class",
+		params: []apiParam{assignID, userID}, data: "Submission", list: true},
+	{method: "POST", path: "/format", id: "formatCode", summary: "Format the code files of a txtar document",
+		form: []apiParam{
+			{"body", "form", "string", "txtar document"},
+			{"import", "form", "string", "true to fix the imports of Go files"},
+		},
+		data: "FormatResponse"},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+)
+
+// OpenAPI returns the OpenAPI 3 document of the /api/v1 surface, as
+// JSON.
+func OpenAPI() []byte {
+	openAPIOnce.Do(func() {
+		doc, err := json.MarshalIndent(openAPI(), "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		openAPIDoc = doc
+	})
+	return openAPIDoc
+}
+
+type object = map[string]interface{}
+
+func openAPI() object {
+	schemas := object{
+		"Error": object{
+			"type":     "object",
+			"required": []string{"code", "msg"},
+			"properties": object{
+				"code": object{"type": "integer"},
+				"msg":  object{"type": "string"},
+				"data": object{"description": "details, such as the format errors of each file"},
+			},
+		},
+	}
+	named := make(map[reflect.Type]string)
+	for _, s := range apiSchemas {
+		named[s.typ] = s.name
+	}
+	for _, s := range apiSchemas {
+		schemas[s.name] = structSchema(s.typ, named)
+	}
+
+	errorResponse := object{
+		"description": "error",
+		"content": object{
+			"application/json": object{"schema": ref("Error")},
+		},
+	}
+	paths := object{}
+	for _, op := range apiOps {
+		item, _ := paths[op.path].(object)
+		if item == nil {
+			item = object{}
+			paths[op.path] = item
+		}
+		var params []object
+		for _, p := range op.params {
+			params = append(params, object{
+				"name":        p.name,
+				"in":          p.in,
+				"required":    p.in == "path",
+				"description": p.desc,
+				"schema":      object{"type": p.typ},
+			})
+		}
+		operation := object{
+			"operationId": op.id,
+			"summary":     op.summary,
+			"responses": object{
+				"200":     okResponse(op),
+				"default": errorResponse,
+			},
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if op.form != nil {
+			props := object{}
+			for _, f := range op.form {
+				schema := object{"type": f.typ, "description": f.desc}
+				if f.typ == "binary" {
+					schema = object{"type": "string", "format": "binary", "description": f.desc}
+				}
+				props[f.name] = schema
+			}
+			operation["requestBody"] = object{
+				"required": true,
+				"content": object{
+					"multipart/form-data": object{
+						"schema": object{"type": "object", "properties": props},
+					},
+				},
+			}
+		}
+		item[strings.ToLower(op.method)] = operation
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "spx-back",
+			"version": APIVersion,
+		},
+		"servers":    []object{{"url": "/api/v1"}},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// okResponse describes the successful response of op: the data wrapped
+// in the usual {code, msg, data} envelope.
+func okResponse(op apiOp) object {
+	if strings.Contains(op.data, "/") {
+		return object{
+			"description": "OK",
+			"content": object{
+				op.data: object{"schema": object{"type": "string", "format": "binary"}},
+			},
+		}
+	}
+	props := object{
+		"code": object{"type": "integer", "example": http.StatusOK},
+		"msg":  object{"type": "string"},
+	}
+	required := []string{"code", "msg"}
+	if op.data != "" {
+		var data object = ref(op.data)
+		if op.list {
+			data = object{"type": "array", "items": data}
+		}
+		props["data"] = data
+		required = append(required, "data")
+	}
+	return object{
+		"description": "OK",
+		"content": object{
+			"application/json": object{
+				"schema": object{
+					"type":       "object",
+					"required":   required,
+					"properties": props,
+				},
+			},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structSchema returns the schema of the struct type t, with the fields
+// named as encoding/json names them. Types in named are referenced
+// rather than inlined.
+func structSchema(t reflect.Type, named map[reflect.Type]string) object {
+	props := object{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n, _, _ := strings.Cut(tag, ","); n != "" {
+				name = n
+			}
+		}
+		props[name] = typeSchema(f.Type, named)
+	}
+	return object{"type": "object", "properties": props}
+}
+
+func typeSchema(t reflect.Type, named map[reflect.Type]string) object {
+	if name, ok := named[t]; ok {
+		return ref(name)
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), named)
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return object{"type": "string", "format": "byte"}
+		}
+		return object{"type": "array", "items": typeSchema(t.Elem(), named)}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": typeSchema(t.Elem(), named)}
+	case reflect.Struct:
+		if t == timeType {
+			return object{"type": "string", "format": "date-time"}
+		}
+		return structSchema(t, named)
+	}
+	return object{}
+}
@@ -1,306 +1,352 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"log/slog"
 	"mime/multipart"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/Mrkuib/spx-back/internal/common"
+	"github.com/XSAM/otelsql"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
 	_ "github.com/qiniu/go-cdk-driver/kodoblob"
+	"go.opentelemetry.io/otel/attribute"
 	"gocloud.dev/blob"
 	"golang.org/x/mod/modfile"
-	"golang.org/x/tools/imports"
 )
 
 var (
 	ErrNotExist = os.ErrNotExist
 )
 
-type Config struct {
-	Driver string // database driver. default is `mysql`.
-	DSN    string // database data source name
-	BlobUS string // blob URL scheme
-}
-
 type Asset struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	AuthorId  string    `json:"authorId"`
-	Category  string    `json:"category"`
-	IsPublic  int       `json:"isPublic"`
-	Address   string    `json:"address"`
-	AssetType string    `json:"assetType"`
-	Status    int       `json:"status"`
-	CTime     time.Time `json:"cTime"`
-	UTime     time.Time `json:"uTime"`
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	AuthorId      string    `json:"authorId"`
+	Category      string    `json:"category"`
+	IsPublic      int       `json:"isPublic"`
+	Address       string    `json:"address"`
+	AssetType     string    `json:"assetType"`
+	Status        int       `json:"status"`
+	ViewCount     int64     `json:"viewCount"`
+	FavoriteCount int64     `json:"favoriteCount"`
+	Thumbnail     string    `json:"thumbnail"`  // key of the preview image, see assetThumbnail
+	Size          int64     `json:"size"`       // bytes stored for the files at Address
+	Duration      float64   `json:"duration"`   // seconds, for sounds
+	SampleRate    int       `json:"sampleRate"` // for sounds
+	Channels      int       `json:"channels"`   // for sounds
+	CTime         time.Time `json:"cTime"`
+	UTime         time.Time `json:"uTime"`
 }
 
 type CodeFile struct {
-	ID       string
-	Name     string
-	AuthorId string
-	Address  string
-	Ctime    time.Time
-	Utime    time.Time
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	AuthorId   string    `json:"authorId"`
+	Address    string    `json:"address"`
+	Size       int64     `json:"size"`                 // size of the bundle at Address
+	ForkedFrom string    `json:"forkedFrom,omitempty"` // ID of the project this one was forked from
+	Visibility string    `json:"visibility"`           // VisibilityPrivate, VisibilityUnlisted or VisibilityPublic
+	ShareToken string    `json:"shareToken,omitempty"` // set while the project is shared, see ShareProject
+	ViewCount  int64     `json:"viewCount"`
+	LikeCount  int64     `json:"likeCount"`
+	Thumbnail  string    `json:"thumbnailUrl,omitempty"` // URL of the preview image, see projectThumbnail
+	Ctime      time.Time `json:"cTime"`
+	Utime      time.Time `json:"uTime"`
 }
 
 type Project struct {
-	bucket *blob.Bucket
-	db     *sql.DB
+	bucket  *blob.Bucket
+	db      *sql.DB
+	conf    *Config
+	limits  fileLimits
+	metrics *metrics
+	log     *slog.Logger
+	limiter *rateLimiter
+	views   *viewLog
+	builds  chan struct{} // semaphore bounding the builds run at once
+
+	shutdownTracing func(context.Context) error
+	stop            context.CancelFunc // stops the background jobs
+	jobs            sync.WaitGroup
 }
 
 type FormatError struct {
-	Column int
-	Line   int
-	Msg    string
+	Column int    `json:"column"`
+	Line   int    `json:"line"`
+	Msg    string `json:"msg"`
 }
 type FormatResponse struct {
-	Body  string
-	Error FormatError
+	Body  string      `json:"body"`
+	Error FormatError `json:"error"`
 }
 
 
+// New opens the database and bucket described by conf. Settings left
+// zero take their defaults; conf must be valid otherwise, see
+// LoadConfig.
 func New(ctx context.Context, conf *Config) (ret *Project, err error) {
-	_ = godotenv.Load("../.env")
 	if conf == nil {
 		conf = new(Config)
 	}
-	driver := conf.Driver
-	dsn := conf.DSN
-	bus := conf.BlobUS
-	if driver == "" {
-		driver = "mysql"
+	conf.setDefaults()
+	if err = conf.Validate(); err != nil {
+		return
 	}
-	if dsn == "" {
-		dsn = os.Getenv("GOP_SPX_DSN")
+	limiter, err := newRateLimiter(conf.RateLimits)
+	if err != nil {
+		return
+	}
+	limits := fileLimits{
+		numFiles: conf.MaxFiles,
+		nameLen:  conf.MaxFileNameLen,
+		depth:    conf.MaxFileDepth,
+		size:     conf.MaxUnpackedMB << 20,
 	}
-	if bus == "" {
-		bus = os.Getenv("GOP_SPX_BLOBUS")
+	shutdownTracing, err := setupTracing(ctx, conf.TraceExporter)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
 	}
-	bucket, err := blob.OpenBucket(ctx, bus)
+	bucket, err := blob.OpenBucket(ctx, conf.BlobUS)
 	if err != nil {
-		println(err.Error())
+		shutdownTracing(ctx)
 		return
 	}
 
-	db, err := sql.Open(driver, dsn)
+	db, err := otelsql.Open(conf.Driver, conf.DSN, otelsql.WithAttributes(attribute.String("db.system", conf.Driver)))
 	if err != nil {
-		println(err.Error())
+		bucket.Close()
+		shutdownTracing(ctx)
 		return
 	}
-	return &Project{bucket, db}, nil
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(conf.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(conf.ConnMaxIdleTime)
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		bucket.Close()
+		shutdownTracing(ctx)
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	ret = &Project{
+		bucket:          bucket,
+		db:              db,
+		conf:            conf,
+		limits:          limits,
+		metrics:         newMetrics(db),
+		log:             newLogger(conf),
+		limiter:         limiter,
+		views:           newViewLog(),
+		builds:          make(chan struct{}, conf.MaxBuilds),
+		shutdownTracing: shutdownTracing,
+	}
+	jobsCtx, stop := context.WithCancel(context.Background())
+	ret.stop = stop
+	ret.jobs.Add(1)
+	go func() {
+		defer ret.jobs.Done()
+		ret.refreshTrending(jobsCtx)
+	}()
+	return ret, nil
 }
 
-// Find file address from db
-func (p *Project) FileInfo(ctx context.Context, id string) (*CodeFile, error) {
-	if id != "" {
-		var address string
-		query := "SELECT address FROM project WHERE id = ?"
-		err := p.db.QueryRow(query, id).Scan(&address)
-		if err != nil {
-			return nil, err
-		}
-		cloudFile := &CodeFile{
-			ID:      id,
-			Address: address,
-		}
-		return cloudFile, nil
-	}
-	return nil, ErrNotExist
+// Close stops the background jobs, releases the bucket and the database
+// connections, and flushes pending trace spans.
+func (p *Project) Close() error {
+	p.stop()
+	p.jobs.Wait()
+	return errors.Join(p.bucket.Close(), p.db.Close(), p.shutdownTracing(context.Background()))
 }
 
-// Asset returns an Asset.
-func (p *Project) Asset(ctx context.Context, id string) (*Asset, error) {
-	asset, err := common.QueryById[Asset](p.db, id)
+// FileInfo returns the record of project id as user uid sees it. A
+// private project doesn't exist for anyone but its owner, and only the
+// owner gets its share token.
+func (p *Project) FileInfo(ctx context.Context, id, uid string) (*CodeFile, error) {
+	c, err := p.fileInfo(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	if asset == nil {
-		return nil, err
+	if !c.visibleTo(uid) {
+		return nil, ErrNotExist
 	}
-	modifiedAddress, err := p.modifyAddress(asset.Address)
-	if err != nil {
-		return nil, err
+	if uid != c.AuthorId {
+		c.ShareToken = ""
 	}
-	asset.Address = modifiedAddress
-
-	return asset, nil
+	return c, nil
 }
 
-// AssetList list assets
-func (p *Project) AssetList(ctx context.Context, pageIndex string, pageSize string, assetType string) (*common.Pagination[Asset], error) {
-	wheres := []common.FilterCondition{
-		{Column: "asset_type", Operation: "=", Value: assetType},
+// fileInfo returns the record of project id, whoever asks.
+func (p *Project) fileInfo(ctx context.Context, id string) (*CodeFile, error) {
+	if id == "" {
+		return nil, ErrNotExist
 	}
-	pagination, err := common.QueryByPage[Asset](p.db, pageIndex, pageSize, wheres)
-	for i, asset := range pagination.Data {
-		modifiedAddress, err := p.modifyAddress(asset.Address)
-		if err != nil {
-			return nil, err
-		}
-		pagination.Data[i].Address = modifiedAddress
+	c := &CodeFile{ID: id}
+	var forkedFrom, shareToken sql.NullString
+	query := "SELECT name, author_id, address, size, forked_from, visibility, share_token, view_count, like_count, thumbnail, c_time, u_time FROM project WHERE id = ?"
+	err := p.db.QueryRowContext(ctx, query, id).Scan(&c.Name, &c.AuthorId, &c.Address, &c.Size, &forkedFrom, &c.Visibility, &shareToken,
+		&c.ViewCount, &c.LikeCount, &c.Thumbnail, &c.Ctime, &c.Utime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
 	}
 	if err != nil {
 		return nil, err
 	}
-	return pagination, nil
+	c.ForkedFrom = forkedFrom.String
+	c.ShareToken = shareToken.String
+	c.Thumbnail = p.thumbnailURL(c.Thumbnail)
+	return c, nil
 }
 
-// modifyAddress transfers relative path to download url
-func (p *Project) modifyAddress(address string) (string, error) {
-	var data struct {
-		Assets    map[string]string `json:"assets"`
-		IndexJson string            `json:"indexJson"`
+// Asset returns an Asset. Assets saved without a thumbnail get one the
+// first time they are fetched.
+func (p *Project) Asset(ctx context.Context, id string) (*AssetResponse, error) {
+	asset, err := common.QueryById[Asset](ctx, p.db, id)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal([]byte(address), &data); err != nil {
-		return "", err
+	if asset == nil {
+		return nil, ErrNotExist
 	}
-	qiniuPath := os.Getenv("QINIU_PATH") // TODO: Replace with real URL prefix
-	for key, value := range data.Assets {
-		data.Assets[key] = qiniuPath + value
+	if asset.Thumbnail == "" {
+		p.assetThumbnail(ctx, asset)
 	}
-	if data.IndexJson != "" {
-		data.IndexJson = qiniuPath + data.IndexJson
+	return p.assetResponse(asset)
+}
+
+// AssetList list assets of assetType in the given sort order, see
+// assetOrders. An empty sort keeps the order of the table.
+func (p *Project) AssetList(ctx context.Context, pageIndex string, pageSize string, assetType string, sort string) (*common.Pagination[AssetResponse], error) {
+	orderBy, ok := assetOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: sort %q is not recent, popular or views", ErrInvalidParam, sort)
 	}
-	modifiedAddress, err := json.Marshal(data)
+	wheres := []common.FilterCondition{
+		{Column: "asset_type", Operation: "=", Value: assetType},
+	}
+	pagination, err := common.QueryByPage[Asset](ctx, p.db, pageIndex, pageSize, wheres, orderBy)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(modifiedAddress), nil
+	return p.assetPage(pagination)
 }
 
-func (p *Project) SaveProject(ctx context.Context, codeFile *CodeFile, file multipart.File, header *multipart.FileHeader) (*CodeFile, error) {
-	if codeFile.ID == "" {
-		path, err := UploadFile(ctx, p, os.Getenv("PROJECT_PATH"), file, header)
+// assetPage turns a page of assets into a page of responses.
+func (p *Project) assetPage(pagination *common.Pagination[Asset]) (*common.Pagination[AssetResponse], error) {
+	res := &common.Pagination[AssetResponse]{
+		TotalCount: pagination.TotalCount,
+		TotalPage:  pagination.TotalPage,
+		Data:       make([]AssetResponse, len(pagination.Data)),
+	}
+	for i := range pagination.Data {
+		asset, err := p.assetResponse(&pagination.Data[i])
 		if err != nil {
 			return nil, err
 		}
-		codeFile.Address = path
-		codeFile.ID, err = AddProject(p, codeFile)
-		return codeFile, err
-	} else {
-		address := GetProjectAddress(codeFile.ID, p)
-		err := p.bucket.Delete(ctx, address)
-		if err != nil {
+		res.Data[i] = *asset
+	}
+	return res, nil
+}
+
+// SaveProject uploads a project bundle, creates or updates its record
+// and records a new revision. Only the owner, codeFile.AuthorId, may
+// update a project. An empty codeFile.Visibility leaves it as
+// it is, or unlisted for a new project. With format set, every code file of the
+// bundle is formatted first and the save is rejected with a
+// *ProjectFormatError if any of them fails to parse. The thumbnail of the
+// project is rendered from the bundle, see projectThumbnail.
+func (p *Project) SaveProject(ctx context.Context, codeFile *CodeFile, file multipart.File, header *multipart.FileHeader, format bool) (*CodeFile, error) {
+	if codeFile.Visibility != "" {
+		if err := checkVisibility(codeFile.Visibility); err != nil {
 			return nil, err
 		}
-		path, err := UploadFile(ctx, p, os.Getenv("PROJECT_PATH"), file, header)
-		if err != nil {
+	}
+	if codeFile.ID != "" {
+		if _, err := p.owned(ctx, codeFile.ID, codeFile.AuthorId); err != nil {
+			return nil, err
+		}
+	}
+	data, err := readUpload(file, p.limits)
+	if err != nil {
+		return nil, err
+	}
+	if format {
+		if data, err = p.formatProject(ctx, data); err != nil {
 			return nil, err
 		}
-		codeFile.Address = path
-		return codeFile, UpdateProject(p, codeFile)
 	}
+	size := int64(len(data))
+	if err := p.checkQuota(ctx, codeFile, size); err != nil {
+		return nil, err
+	}
+	path, err := UploadReader(ctx, p, p.conf.ProjectPath, header.Filename, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	codeFile.Address = path
+	codeFile.Size = size
+	codeFile.Thumbnail = ""
+	if fs, err := unpackProject(data, p.limits); err == nil {
+		codeFile.Thumbnail = p.projectThumbnail(ctx, fs)
+	}
+	// The bundle of the previous version is kept for its revision.
+	if codeFile.ID == "" {
+		codeFile.ID, err = AddProject(ctx, p, codeFile)
+	} else {
+		err = UpdateProject(ctx, p, codeFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = AddRevision(ctx, p, codeFile.ID, codeFile.Address, codeFile.Size); err != nil {
+		return nil, err
+	}
+	return codeFile, nil
 }
 
+// CodeFmt formats the files of a txtar body. Problems with the body
+// itself, such as exceeding the configured limits, are returned as err;
+// problems with the code are reported in res.Error.
+func (p *Project) CodeFmt(ctx context.Context, body, fiximport string) (res *FormatResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, fmtTimeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		p.metrics.fmtDuration.Observe(time.Since(start).Seconds())
+	}()
 
-func (p *Project)CodeFmt(ctx context.Context,body,fiximport string) (res *FormatResponse){
-	
-	fs, err := splitFiles([]byte(body))
+	fs, err := splitFiles([]byte(body), p.limits)
 	if err != nil {
-		fmtErr:=ExtractErrorInfo(err.Error())
-		res=&FormatResponse{
-			Body: "",
-			Error: fmtErr,
-		}
-		return
+		return nil, err
 	}
 
 	fixImports := fiximport != ""
 	for _, f := range fs.files {
-		switch {
-		case path.Ext(f) == ".go":
-			var out []byte
-			var err error
-			in := fs.Data(f)
-			if fixImports {
-				// TODO: pass options to imports.Process so it
-				// can find symbols in sibling files.
-				out, err = imports.Process(f, in, nil)
-			} else {
-				var tmpDir string
-				tmpDir, err = os.MkdirTemp("", "gopformat")
-				if err != nil {
-					fmtErr:=ExtractErrorInfo(err.Error())
-					res=&FormatResponse{
-						Body: "",
-						Error: fmtErr,
-					}
-					return
-				}
-				defer os.RemoveAll(tmpDir)
-				tmpGopFile := filepath.Join(tmpDir, "prog.gop")
-				if err = os.WriteFile(tmpGopFile, in, 0644); err != nil {
-					fmtErr:=ExtractErrorInfo(err.Error())
-					res=&FormatResponse{
-						Body: "",
-						Error: fmtErr,
-					}
-					return
-				}
-				cmd := exec.Command("gop", "fmt", "-smart", tmpGopFile)
-				//gop fmt returns error result in stdout, so we do not need to handle stderr
-				//err is to check gop fmt return code
-				var fmtErr []byte
-				fmtErr, err = cmd.Output()
-				if err != nil {
-					fmtErr:=ExtractErrorInfo(strings.Replace(string(fmtErr), tmpGopFile, "prog.gop", -1))
-					res=&FormatResponse{
-						Body: "",
-						Error: fmtErr,
-					}
-					return
-				}
-				out, err = ioutil.ReadFile(tmpGopFile)
-				if err != nil {
-					err = errors.New("interval error when formatting gop code")
-				}
-			}
-			if err != nil {
-				errMsg := err.Error()
-				if !fixImports {
-					// Unlike imports.Process, format.Source does not prefix
-					// the error with the file path. So, do it ourselves here.
-					errMsg = fmt.Sprintf("%v:%v", f, errMsg)
-				}
-				fmtErr:=ExtractErrorInfo(errMsg)
-					res=&FormatResponse{
-						Body: "",
-						Error: fmtErr,
-					}
-					return
-			}
-			fs.AddFile(f, out)
-		case path.Base(f) == "go.mod":
-			out, err := formatGoMod(f, fs.Data(f))
-			if err != nil {
-				fmtErr:=ExtractErrorInfo(err.Error())
-					res=&FormatResponse{
-						Body: "",
-						Error: fmtErr,
-					}
-					return
+		if !isCodeFile(f) {
+			continue
+		}
+		out, err := formatFile(ctx, f, fs.Data(f), fixImports)
+		if err != nil {
+			p.metrics.fmtFailures.Inc()
+			res = &FormatResponse{
+				Body:  "",
+				Error: ExtractErrorInfo(err.Error()),
 			}
-			fs.AddFile(f, out)
+			return res, nil
 		}
+		fs.AddFile(f, out)
 	}
-	res=&FormatResponse{
-		Body: string(fs.Format()),
+	res = &FormatResponse{
+		Body:  string(fs.Format()),
 		Error: FormatError{},
 	}
-	return
+	return res, nil
 }
 
 func formatGoMod(file string, data []byte) ([]byte, error) {
@@ -309,4 +355,4 @@ func formatGoMod(file string, data []byte) ([]byte, error) {
 		return nil, err
 	}
 	return f.Format()
-}
\ No newline at end of file
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// A QuotaError reports an upload that would take a user over their
+// storage quota.
+type QuotaError struct {
+	User  string
+	Used  int64 // bytes stored
+	Size  int64 // bytes uploaded
+	Quota int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("storage quota of %s exceeded: %s already used, upload is %s",
+		formatBytes(e.Quota), formatBytes(e.Used), formatBytes(e.Size))
+}
+
+func formatBytes(n int64) string {
+	if n < 1<<20 {
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+// storageUsage returns the bytes stored by user over the projects and
+// assets they own, including the bundles kept for the older revisions
+// of their projects.
+func (p *Project) storageUsage(ctx context.Context, user string) (int64, error) {
+	var projects, revisions, assets int64
+	err := p.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM project WHERE author_id = ?", user).Scan(&projects)
+	if err != nil {
+		return 0, err
+	}
+	query := "SELECT COALESCE(SUM(r.size), 0) FROM project_revision r JOIN project pr ON pr.id = r.project_id " +
+		"WHERE pr.author_id = ? AND r.address != pr.address"
+	if err = p.db.QueryRowContext(ctx, query, user).Scan(&revisions); err != nil {
+		return 0, err
+	}
+	err = p.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM asset WHERE author_id = ? AND status != 0", user).Scan(&assets)
+	if err != nil {
+		return 0, err
+	}
+	return projects + revisions + assets, nil
+}
+
+// checkQuota returns a *QuotaError if storing size bytes for c would
+// take its owner over Config.StorageQuotaMB. When c is an existing
+// project, its current bundle still counts: it is kept for its
+// revision. Concurrent uploads may all pass, so the quota is a soft one.
+func (p *Project) checkQuota(ctx context.Context, c *CodeFile, size int64) error {
+	owner := c.AuthorId
+	if c.ID != "" {
+		query := "SELECT author_id FROM project WHERE id = ?"
+		err := p.db.QueryRowContext(ctx, query, c.ID).Scan(&owner)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+	}
+	used, err := p.storageUsage(ctx, owner)
+	if err != nil {
+		return err
+	}
+	quota := int64(p.conf.StorageQuotaMB) << 20
+	if used+size > quota {
+		return &QuotaError{User: owner, Used: used, Size: size, Quota: quota}
+	}
+	return nil
+}
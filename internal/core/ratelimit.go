@@ -0,0 +1,190 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultRateLimits keeps the routes that run gop or store uploads well
+// below the others, as well as those whose counts made up user ids could
+// inflate.
+const defaultRateLimits = "*=10:20," +
+	"/project/fmt=1:5,/project/save=0.5:5,/project/import=0.5:5,/project/:id/build=0.1:3,/project/:id/fork=0.5:5," +
+	"/api/v1/format=1:5,POST /api/v1/projects=0.5:5,PUT /api/v1/projects/:id=0.5:5,/api/v1/imports=0.5:5,/api/v1/projects/:id/build=0.1:3," +
+	"POST /api/v1/projects/:id/forks=0.5:5,POST /project/:id/comments=0.2:5,POST /api/v1/projects/:id/comments=0.2:5," +
+	"POST /api/v1/assignments/:id/accept=0.5:5,/project/import/sb3=0.2:3,/api/v1/imports/sb3=0.2:3," +
+	"POST /asset=0.5:5,POST /api/v1/assets=0.5:5," +
+	"POST /project/:id/like=0.2:10,POST /api/v1/projects/:id/like=0.2:10,POST /asset/:id/favorite=0.2:10,POST /api/v1/assets/:id/favorite=0.2:10," +
+	"POST /comment/:id/report=0.1:5,POST /api/v1/comments/:id/report=0.1:5"
+
+// rateLimiterIdle is how long the bucket of a client is kept after its
+// last request.
+const rateLimiterIdle = 10 * time.Minute
+
+// A rateLimit is a token bucket refilled with rate tokens per second and
+// holding up to burst of them.
+type rateLimit struct {
+	rate  rate.Limit
+	burst int
+}
+
+// parseRateLimits parses a comma separated list of route=rate:burst
+// entries, such as "/project/fmt=1:5". Path parameters in routes may be
+// written as :name, and a route may start with a method, as in
+// "PUT /api/v1/projects/:id", to limit only that method. The route *
+// applies to the routes not listed.
+func parseRateLimits(s string) (map[string]rateLimit, error) {
+	limits := make(map[string]rateLimit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, spec, ok1 := strings.Cut(entry, "=")
+		r, b, ok2 := strings.Cut(spec, ":")
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%q is not route=rate:burst", entry)
+		}
+		perSec, err := strconv.ParseFloat(r, 64)
+		if err != nil || perSec <= 0 {
+			return nil, fmt.Errorf("%q: rate must be a positive number", entry)
+		}
+		burst, err := strconv.Atoi(b)
+		if err != nil || burst <= 0 {
+			return nil, fmt.Errorf("%q: burst must be a positive integer", entry)
+		}
+		if method, path, ok := strings.Cut(route, " "); ok {
+			route = method + " " + routePattern(path)
+		} else if route != "*" {
+			route = routePattern(route)
+		}
+		limits[route] = rateLimit{rate: rate.Limit(perSec), burst: burst}
+	}
+	return limits, nil
+}
+
+// A RateLimitError reports a request rejected by the rate limiter.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry in %v", e.RetryAfter.Round(time.Second))
+}
+
+// A rateLimiter keeps a token bucket per client and route. Clients are
+// identified by IP and by user, and a request must get a token from
+// both.
+type rateLimiter struct {
+	limits map[string]rateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*clientBucket
+	routes    map[string]bool // routes with their own bucket under *
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	lim  *rate.Limiter
+	seen time.Time
+}
+
+func newRateLimiter(spec string) (*rateLimiter, error) {
+	limits, err := parseRateLimits(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		limits:  limits,
+		buckets: make(map[string]*clientBucket),
+		routes:  make(map[string]bool),
+	}, nil
+}
+
+// reserve takes a token for client on the route of a request. If there
+// is none, it returns how long to wait for one. Routes limited by the *
+// entry each get their own bucket too, up to maxRouteLabels of them, so
+// clients requesting odd paths can't blow up the number of buckets;
+// routes past that share the bucket of *.
+func (l *rateLimiter) reserve(client, method, route string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var limit rateLimit
+	var key string
+	for _, k := range []string{method + " " + route, route, "*"} {
+		var ok bool
+		if limit, ok = l.limits[k]; ok {
+			key = client + " " + k
+			if k == "*" && (l.routes[route] || len(l.routes) < maxRouteLabels) {
+				l.routes[route] = true
+				key = client + " " + route
+			}
+			break
+		}
+	}
+	if key == "" {
+		return 0
+	}
+
+	if now.Sub(l.lastSweep) > rateLimiterIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.seen) > rateLimiterIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &clientBucket{lim: rate.NewLimiter(limit.rate, limit.burst)}
+		l.buckets[key] = b
+	}
+	b.seen = now
+	r := b.lim.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d
+	}
+	return 0
+}
+
+// ClientIP returns the IP address request r comes from.
+func ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// RateLimit wraps h to reject requests once the client IP or the user
+// has used up the token bucket of the route, see Config.RateLimits.
+// Rejected requests get a 429 with a Retry-After header.
+func (p *Project) RateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		route := routePattern(r.URL.Path)
+		wait := p.limiter.reserve("ip:"+ClientIP(r), r.Method, route, now)
+		if wait == 0 {
+			// The user is only looked at once the IP passed, as it may
+			// take parsing the form.
+			if user := UserID(r); user != "" {
+				wait = p.limiter.reserve("user:"+user, r.Method, route, now)
+			}
+		}
+		if wait == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, r, &RateLimitError{RetryAfter: wait})
+	})
+}
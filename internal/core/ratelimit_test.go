@@ -0,0 +1,46 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l, err := newRateLimiter("*=1:1,POST /project/:id/fork=1:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, tt := range []struct {
+		client, method, route string
+		wait                  bool
+	}{
+		{"ip:a", "GET", "/project/7", false},
+		{"ip:a", "GET", "/project/7", true},
+		{"ip:a", "GET", "/list/asset/Recent", false}, // routes under * have their own bucket
+		{"ip:b", "GET", "/project/7", false},
+		{"ip:a", "POST", "/project/7/fork", false},
+		{"ip:a", "POST", "/project/7/fork", false},
+		{"ip:a", "POST", "/project/7/fork", true},
+		{"ip:a", "GET", "/project/7/fork", false}, // only POST has its own limit
+	} {
+		if wait := l.reserve(tt.client, tt.method, routePattern(tt.route), now); (wait > 0) != tt.wait {
+			t.Errorf("%s %s %s: wait %v, want a wait: %v", tt.client, tt.method, tt.route, wait, tt.wait)
+		}
+	}
+}
+
+func TestRateLimiterCapsRoutes(t *testing.T) {
+	l, err := newRateLimiter("*=1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < 2*maxRouteLabels; i++ {
+		l.reserve("ip:a", "GET", fmt.Sprintf("/made/up/%c%c", 'a'+i%26, 'a'+i/26), now)
+	}
+	if n := len(l.buckets); n > maxRouteLabels+1 {
+		t.Errorf("%d buckets for one client, want at most %d", n, maxRouteLabels+1)
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// A Response is the body of every API response. Code repeats the HTTP
+// status code.
+type Response struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// OK wraps the data of a successful response.
+func OK(data interface{}) *Response {
+	return &Response{Code: http.StatusOK, Msg: "ok", Data: data}
+}
+
+// ErrorBody returns the response to err, answered with status code. A
+// *ProjectFormatError carries the errors of each file as data.
+func ErrorBody(code int, err error) *Response {
+	var fmtErr *ProjectFormatError
+	if errors.As(err, &fmtErr) {
+		return &Response{Code: code, Msg: "format failed", Data: fmtErr.Files}
+	}
+	return &Response{Code: code, Msg: err.Error()}
+}
+
+// AssetAddress locates the files of an asset. Asset.Address stores it
+// as JSON, with keys relative to the bucket.
+type AssetAddress struct {
+	Assets    map[string]string `json:"assets"`          // file name to URL
+	IndexJson string            `json:"indexJson"`       // URL of the index.json of a sprite
+	Atlas     *SpriteAtlas      `json:"atlas,omitempty"` // costumes of a sprite packed together, see spriteAtlas
+}
+
+// AssetResponse is an Asset as the API returns it, with its address
+// decoded and turned into URLs.
+type AssetResponse struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	AuthorId      string        `json:"authorId"`
+	Category      string        `json:"category"`
+	IsPublic      int           `json:"isPublic"`
+	Address       *AssetAddress `json:"address"`
+	AssetType     string        `json:"assetType"`
+	Status        int           `json:"status"`
+	ViewCount     int64         `json:"viewCount"`
+	FavoriteCount int64         `json:"favoriteCount"`
+	ThumbnailURL  string        `json:"thumbnailUrl,omitempty"`
+	Duration      float64       `json:"duration,omitempty"`   // seconds, for sounds
+	SampleRate    int           `json:"sampleRate,omitempty"` // for sounds
+	Channels      int           `json:"channels,omitempty"`   // for sounds
+	CTime         time.Time     `json:"cTime"`
+	UTime         time.Time     `json:"uTime"`
+}
+
+func (p *Project) assetResponse(a *Asset) (*AssetResponse, error) {
+	address, err := p.assetAddress(a.Address)
+	if err != nil {
+		return nil, fmt.Errorf("asset %s: %w", a.ID, err)
+	}
+	return &AssetResponse{
+		ID:            a.ID,
+		Name:          a.Name,
+		AuthorId:      a.AuthorId,
+		Category:      a.Category,
+		IsPublic:      a.IsPublic,
+		Address:       address,
+		AssetType:     a.AssetType,
+		Status:        a.Status,
+		ViewCount:     a.ViewCount,
+		FavoriteCount: a.FavoriteCount,
+		ThumbnailURL:  p.thumbnailURL(a.Thumbnail),
+		Duration:      a.Duration,
+		SampleRate:    a.SampleRate,
+		Channels:      a.Channels,
+		CTime:         a.CTime,
+		UTime:         a.UTime,
+	}, nil
+}
+
+// assetAddress decodes a stored asset address and turns its keys into
+// download URLs.
+func (p *Project) assetAddress(address string) (*AssetAddress, error) {
+	var data AssetAddress
+	if err := json.Unmarshal([]byte(address), &data); err != nil {
+		return nil, err
+	}
+	qiniuPath := p.conf.QiniuPath
+	for key, value := range data.Assets {
+		data.Assets[key] = qiniuPath + value
+	}
+	if data.IndexJson != "" {
+		data.IndexJson = qiniuPath + data.IndexJson
+	}
+	if data.Atlas != nil {
+		data.Atlas.Image = qiniuPath + data.Atlas.Image
+	}
+	return &data, nil
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssetAddress(t *testing.T) {
+	p := &Project{conf: &Config{QiniuPath: "https://cdn.example.com/"}}
+	tests := []struct {
+		address string
+		want    *AssetAddress
+		wantErr bool
+	}{
+		{
+			address: `{"assets":{"cat.png":"spirit/a.png","meow.wav":"spirit/b.wav"},"indexJson":"spirit/index.json"}`,
+			want: &AssetAddress{
+				Assets: map[string]string{
+					"cat.png":  "https://cdn.example.com/spirit/a.png",
+					"meow.wav": "https://cdn.example.com/spirit/b.wav",
+				},
+				IndexJson: "https://cdn.example.com/spirit/index.json",
+			},
+		},
+		{
+			address: `{"assets":{"bg.png":"backdrop/c.png"}}`,
+			want: &AssetAddress{
+				Assets: map[string]string{"bg.png": "https://cdn.example.com/backdrop/c.png"},
+			},
+		},
+		{address: `{}`, want: &AssetAddress{}},
+		{address: `spirit/a.png`, wantErr: true},
+		{address: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := p.assetAddress(tt.address)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("assetAddress(%q) succeeded, want error", tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("assetAddress(%q): %v", tt.address, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("assetAddress(%q) = %+v, want %+v", tt.address, got, tt.want)
+		}
+	}
+}
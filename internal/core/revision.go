@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Revision is a saved version of a project. Every SaveProject records
+// one, and the bundle it points to is kept so revisions can be diffed.
+type Revision struct {
+	ID        string    `json:"id"`
+	ProjectId string    `json:"projectId"`
+	Address   string    `json:"address"`
+	CTime     time.Time `json:"cTime"`
+}
+
+type FileDiff struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "added", "removed" or "modified"
+	Diff   string `json:"diff"`
+}
+
+type AssetChange struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`         // "added", "removed" or "modified"
+	From   string `json:"from,omitempty"` // sha256 of the old content
+	To     string `json:"to,omitempty"`   // sha256 of the new content
+}
+
+type ProjectDiff struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Files  []FileDiff    `json:"files"`
+	Assets []AssetChange `json:"assets"`
+}
+
+func AddRevision(ctx context.Context, p *Project, projectId, address string, size int64) (string, error) {
+	sqlStr := "insert into project_revision (project_id, address, size, c_time) values (?, ?, ?, ?)"
+	res, err := p.db.ExecContext(ctx, sqlStr, projectId, address, size, time.Now())
+	if err != nil {
+		return "", err
+	}
+	idInt, err := res.LastInsertId()
+	return strconv.Itoa(int(idInt)), err
+}
+
+// Revisions lists the revisions of a project visible to user uid,
+// newest first.
+func (p *Project) Revisions(ctx context.Context, id, uid string) ([]Revision, error) {
+	if _, err := p.FileInfo(ctx, id, uid); err != nil {
+		return nil, err
+	}
+	query := "SELECT id, project_id, address, c_time FROM project_revision WHERE project_id = ? ORDER BY id DESC"
+	rows, err := p.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revs []Revision
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.ID, &r.ProjectId, &r.Address, &r.CTime); err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	return revs, rows.Err()
+}
+
+// revisionAddress returns the bundle address of revision rev of project
+// id. An empty rev means the current version of the project.
+func (p *Project) revisionAddress(ctx context.Context, id, rev string) (string, error) {
+	if rev == "" {
+		if address := GetProjectAddress(ctx, id, p); address != "" {
+			return address, nil
+		}
+		return "", ErrNotExist
+	}
+	var address string
+	query := "SELECT address FROM project_revision WHERE id = ? AND project_id = ?"
+	if err := p.db.QueryRowContext(ctx, query, rev, id).Scan(&address); err != nil {
+		return "", ErrNotExist
+	}
+	return address, nil
+}
+
+// Diff compares two revisions of a project. Code files are compared
+// line by line and reported as unified diffs; all other files are
+// treated as assets and compared by content hash. Unlike an Asset, a
+// project has no address JSON with an assets map: its address is the
+// key of the whole bundle, so its assets are read from the bundles
+// themselves. The hashes are those naming the files in the
+// assetsManifest of an export. An empty to means the current version of
+// the project. The project must be visible to user uid.
+func (p *Project) Diff(ctx context.Context, id, uid, from, to string) (*ProjectDiff, error) {
+	if from == "" {
+		return nil, fmt.Errorf("%w: from revision is required", ErrInvalidParam)
+	}
+	if _, err := p.FileInfo(ctx, id, uid); err != nil {
+		return nil, err
+	}
+	var sets [2]*fileSet
+	for i, rev := range []string{from, to} {
+		address, err := p.revisionAddress(ctx, id, rev)
+		if err != nil {
+			return nil, err
+		}
+		data, err := p.readBlob(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		if sets[i], err = unpackProject(data, p.limits); err != nil {
+			return nil, err
+		}
+	}
+	a, b := sets[0], sets[1]
+
+	names := append([]string(nil), a.files...)
+	for _, f := range b.files {
+		if !a.Contains(f) {
+			names = append(names, f)
+		}
+	}
+	sort.Strings(names)
+
+	res := &ProjectDiff{From: from, To: to, Files: []FileDiff{}, Assets: []AssetChange{}}
+	for _, f := range names {
+		inA, inB := a.Contains(f), b.Contains(f)
+		if inA && inB && bytes.Equal(a.Data(f), b.Data(f)) {
+			continue
+		}
+		status := "modified"
+		switch {
+		case !inA:
+			status = "added"
+		case !inB:
+			status = "removed"
+		}
+		if isCodeFile(f) {
+			oldName, newName := "a/"+f, "b/"+f
+			if !inA {
+				oldName = "/dev/null"
+			}
+			if !inB {
+				newName = "/dev/null"
+			}
+			res.Files = append(res.Files, FileDiff{
+				Name:   f,
+				Status: status,
+				Diff:   unifiedDiff(oldName, newName, a.Data(f), b.Data(f)),
+			})
+			continue
+		}
+		change := AssetChange{Name: f, Status: status}
+		if inA {
+			change.From = contentHash(a.Data(f))
+		}
+		if inB {
+			change.To = contentHash(b.Data(f))
+		}
+		res.Assets = append(res.Assets, change)
+	}
+	return res, nil
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
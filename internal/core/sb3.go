@@ -0,0 +1,450 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"mime/multipart"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sb3Manifest is the file of an sb3 archive describing the project. The
+// other files are the costumes and sounds it refers to.
+const sb3Manifest = "project.json"
+
+// An Sb3Import is the result of ImportSb3: the new project, the asset
+// of each sprite and sound, and the blocks that couldn't be translated.
+type Sb3Import struct {
+	Project     *CodeFile                 `json:"project"`
+	Sprites     map[string]*AssetResponse `json:"sprites"` // spx sprite name to its asset
+	Sounds      map[string]*AssetResponse `json:"sounds"`  // spx sound name to its asset
+	Unsupported []UnsupportedBlock        `json:"unsupported"`
+}
+
+// An UnsupportedBlock counts the Scratch blocks of an opcode that
+// ImportSb3 left as TODO comments in the code of a sprite.
+type UnsupportedBlock struct {
+	Sprite string `json:"sprite"` // spx sprite name, or "main" for the stage
+	Opcode string `json:"opcode"`
+	Count  int    `json:"count"`
+}
+
+type sb3Project struct {
+	Targets []*sb3Target `json:"targets"`
+}
+
+type sb3Target struct {
+	IsStage        bool                       `json:"isStage"`
+	Name           string                     `json:"name"`
+	Blocks         map[string]json.RawMessage `json:"blocks"` // a block, or an array for a loose variable reporter
+	Costumes       []sb3Asset                 `json:"costumes"`
+	Sounds         []sb3Asset                 `json:"sounds"`
+	CurrentCostume int                        `json:"currentCostume"`
+	LayerOrder     int                        `json:"layerOrder"`
+	Visible        bool                       `json:"visible"`
+	X              float64                    `json:"x"`
+	Y              float64                    `json:"y"`
+	Size           float64                    `json:"size"`      // in percent
+	Direction      float64                    `json:"direction"` // 90 is right
+	Draggable      bool                       `json:"draggable"`
+}
+
+// An sb3Asset is a costume or a sound.
+type sb3Asset struct {
+	Name             string  `json:"name"`
+	AssetID          string  `json:"assetId"`
+	Md5ext           string  `json:"md5ext"`
+	DataFormat       string  `json:"dataFormat"`
+	BitmapResolution float64 `json:"bitmapResolution"`
+	RotationCenterX  float64 `json:"rotationCenterX"`
+	RotationCenterY  float64 `json:"rotationCenterY"`
+	Rate             int     `json:"rate"`
+	SampleCount      int     `json:"sampleCount"`
+}
+
+// file returns the name of the file of a in the archive.
+func (a *sb3Asset) file() string {
+	if a.Md5ext != "" {
+		return a.Md5ext
+	}
+	return a.AssetID + "." + a.DataFormat
+}
+
+type sb3Block struct {
+	Opcode   string                       `json:"opcode"`
+	Next     string                       `json:"next"`
+	Inputs   map[string][]json.RawMessage `json:"inputs"`
+	Fields   map[string][]json.RawMessage `json:"fields"`
+	TopLevel bool                         `json:"topLevel"`
+	X        float64                      `json:"x"`
+	Y        float64                      `json:"y"`
+}
+
+// field returns the value of field name of b.
+func (b *sb3Block) field(name string) string {
+	var s string
+	if f := b.Fields[name]; len(f) > 0 {
+		json.Unmarshal(f[0], &s)
+	}
+	return s
+}
+
+// ImportSb3 creates a project for codeFile.AuthorId from a Scratch 3
+// archive, named after the file unless codeFile.Name is set. Costumes and sounds are laid out as an spx project and also
+// stored as assets of codeFile.AuthorId, one per sprite and sound, so
+// they can be added to other projects; they count against the storage
+// quota along with the project. Scripts are translated on a best-effort basis: blocks with
+// no spx equivalent are left as TODO comments and reported.
+func (p *Project) ImportSb3(ctx context.Context, codeFile *CodeFile, file multipart.File, header *multipart.FileHeader) (*Sb3Import, error) {
+	if codeFile.AuthorId == "" {
+		return nil, fmt.Errorf("%w: uid is required", ErrInvalidParam)
+	}
+	if codeFile.Visibility != "" {
+		if err := checkVisibility(codeFile.Visibility); err != nil {
+			return nil, err
+		}
+	}
+	if codeFile.Name == "" {
+		codeFile.Name = strings.TrimSuffix(header.Filename, path.Ext(header.Filename))
+	}
+	data, err := readUpload(file, p.limits)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, zipMagic) {
+		return nil, fmt.Errorf("%w: not an sb3 archive", ErrInvalidParam)
+	}
+	files, err := unzipFiles(data, p.limits)
+	if errors.Is(err, zip.ErrFormat) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidParam, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !files.Contains(sb3Manifest) {
+		return nil, &FileError{Name: sb3Manifest, Reason: "missing file"}
+	}
+	var proj sb3Project
+	if err = json.Unmarshal(files.Data(sb3Manifest), &proj); err != nil {
+		return nil, &FileError{Name: sb3Manifest, Reason: err.Error()}
+	}
+	c, err := convertSb3(&proj, files)
+	if err != nil {
+		return nil, err
+	}
+	if n := c.out.Num(); n > p.limits.numFiles {
+		return nil, &LimitError{What: "number of files", Value: n, Limit: p.limits.numFiles}
+	}
+	bundle, err := zipFiles(c.out)
+	if err != nil {
+		return nil, err
+	}
+	var assets []*importedAsset
+	for _, dir := range c.sprites {
+		assets = append(assets, newImportedAsset(c.out, dir, AssetSprite, codeFile.AuthorId))
+	}
+	for _, dir := range c.sounds {
+		assets = append(assets, newImportedAsset(c.out, dir, AssetSound, codeFile.AuthorId))
+	}
+	size := int64(len(bundle))
+	for _, a := range assets {
+		size += a.Size
+	}
+	if err = p.checkQuota(ctx, codeFile, size); err != nil {
+		return nil, err
+	}
+	codeFile.Size = int64(len(bundle))
+	codeFile.Thumbnail = p.projectThumbnail(ctx, c.out)
+	codeFile.Address, err = UploadReader(ctx, p, p.conf.ProjectPath, codeFile.Name+".zip", bytes.NewReader(bundle))
+	if err != nil {
+		return nil, err
+	}
+	if codeFile.ID, err = AddProject(ctx, p, codeFile); err != nil {
+		return nil, err
+	}
+	if _, err = AddRevision(ctx, p, codeFile.ID, codeFile.Address, codeFile.Size); err != nil {
+		return nil, err
+	}
+	res := &Sb3Import{
+		Project:     codeFile,
+		Sprites:     make(map[string]*AssetResponse),
+		Sounds:      make(map[string]*AssetResponse),
+		Unsupported: c.report(),
+	}
+	for _, a := range assets {
+		stored, err := p.storeAsset(ctx, &a.Asset, a.files, a.sprite)
+		if err != nil {
+			return nil, err
+		}
+		if a.AssetType == AssetSprite {
+			res.Sprites[a.Name] = stored
+		} else {
+			res.Sounds[a.Name] = stored
+		}
+	}
+	return res, nil
+}
+
+// An importedAsset is a sprite or a sound of an imported project, stored as
+// an asset of the importer.
+type importedAsset struct {
+	Asset
+	files  *fileSet
+	sprite *spriteFiles // the costumes of a sprite, if they can be packed
+}
+
+// newImportedAsset returns the asset of type typ made of the files of fs
+// under directory dir, the layout of a sprite or a sound. Unlike
+// uploaded assets, their files aren't rejected if they can't be read:
+// sprites that don't pass checkSprite go without an atlas, and sounds
+// whose headers can't be parsed without their duration and format.
+func newImportedAsset(fs *fileSet, dir, typ, uid string) *importedAsset {
+	a := &importedAsset{
+		Asset: Asset{Name: path.Base(dir), AuthorId: uid, AssetType: typ},
+		files: new(fileSet),
+	}
+	for _, name := range fs.files {
+		if path.Dir(name) == dir {
+			a.files.AddFile(path.Base(name), fs.Data(name))
+			a.Size += int64(len(fs.Data(name)))
+		}
+	}
+	switch typ {
+	case AssetSprite:
+		a.sprite, _ = checkSprite(a.files)
+	case AssetSound:
+		for _, name := range a.files.files {
+			if info, err := parseSound(name, a.files.Data(name)); err == nil {
+				a.Duration, a.SampleRate, a.Channels = info.Duration, info.SampleRate, info.Channels
+			}
+		}
+	}
+	return a
+}
+
+// An sb3Converter lays out a Scratch project as an spx one.
+type sb3Converter struct {
+	files   *fileSet // the sb3 archive
+	out     *fileSet
+	sprites []string // directories of the sprites in out
+	sounds  []string // directories of the sounds in out
+
+	names       map[string]bool   // spx names taken by sprites and sounds
+	spriteNames map[string]string // Scratch sprite name to spx name
+
+	unsupported map[UnsupportedBlock]int // counts by sprite and opcode
+}
+
+func convertSb3(proj *sb3Project, files *fileSet) (*sb3Converter, error) {
+	c := &sb3Converter{
+		files:       files,
+		out:         new(fileSet),
+		names:       map[string]bool{"main": true, "Game": true, "Sprite": true, "Sound": true},
+		spriteNames: make(map[string]string),
+		unsupported: make(map[UnsupportedBlock]int),
+	}
+	var stage *sb3Target
+	var sprites []*sb3Target
+	for _, t := range proj.Targets {
+		if t.IsStage {
+			if stage != nil {
+				return nil, &FileError{Name: sb3Manifest, Reason: "more than one stage"}
+			}
+			stage = t
+			continue
+		}
+		sprites = append(sprites, t)
+		c.spriteNames[t.Name] = spxName(t.Name, "Sprite", c.names)
+	}
+	if stage == nil {
+		return nil, &FileError{Name: sb3Manifest, Reason: "no stage"}
+	}
+	sort.SliceStable(sprites, func(i, j int) bool { return sprites[i].LayerOrder < sprites[j].LayerOrder })
+
+	var decls []string // fields of the game, in main.spx
+	for _, t := range sprites {
+		name := c.spriteNames[t.Name]
+		if err := c.addSprite(name, t); err != nil {
+			return nil, err
+		}
+		decls = append(decls, name+" "+name)
+	}
+	soundNames := make(map[*sb3Target]map[string]string)
+	for _, t := range append([]*sb3Target{stage}, sprites...) {
+		soundNames[t] = make(map[string]string)
+		for i := range t.Sounds {
+			s := &t.Sounds[i]
+			name := spxName(s.Name, "Sound", c.names)
+			soundNames[t][s.Name] = name
+			if err := c.addSound(name, s); err != nil {
+				return nil, err
+			}
+			decls = append(decls, name+" Sound")
+		}
+	}
+	if err := c.addStage(stage, sprites); err != nil {
+		return nil, err
+	}
+
+	var main strings.Builder
+	if len(decls) > 0 {
+		main.WriteString("var (\n")
+		for _, d := range decls {
+			main.WriteString("\t" + d + "\n")
+		}
+		main.WriteString(")\n")
+	}
+	c.translate(&main, "main", stage, soundNames[stage])
+	c.out.AddFile("main.spx", []byte(main.String()))
+	for _, t := range sprites {
+		var code strings.Builder
+		name := c.spriteNames[t.Name]
+		c.translate(&code, name, t, soundNames[t])
+		c.out.AddFile(name+".spx", []byte(code.String()))
+	}
+	return c, nil
+}
+
+// asset returns the content of the file of a, and the name to give it
+// in the spx project, unique among taken.
+func (c *sb3Converter) asset(a *sb3Asset, taken map[string]bool) (string, []byte, error) {
+	file := a.file()
+	if !c.files.Contains(file) {
+		return "", nil, &FileError{Name: file, Reason: "missing file"}
+	}
+	return spxName(a.Name, "asset", taken) + path.Ext(file), c.files.Data(file), nil
+}
+
+type spxCostume struct {
+	Name             string  `json:"name"`
+	Path             string  `json:"path"`
+	X                float64 `json:"x"`
+	Y                float64 `json:"y"`
+	BitmapResolution float64 `json:"bitmapResolution"`
+}
+
+// costumes adds the costumes of t to directory dir of out.
+func (c *sb3Converter) costumes(dir string, t *sb3Target) ([]spxCostume, error) {
+	taken := make(map[string]bool)
+	costumes := make([]spxCostume, len(t.Costumes))
+	for i := range t.Costumes {
+		a := &t.Costumes[i]
+		name, data, err := c.asset(a, taken)
+		if err != nil {
+			return nil, err
+		}
+		c.out.AddFile(path.Join(dir, name), data)
+		resolution := a.BitmapResolution
+		if resolution == 0 {
+			resolution = 1
+		}
+		costumes[i] = spxCostume{Name: a.Name, Path: name, X: a.RotationCenterX, Y: a.RotationCenterY, BitmapResolution: resolution}
+	}
+	return costumes, nil
+}
+
+func (c *sb3Converter) addSprite(name string, t *sb3Target) error {
+	dir := "assets/sprites/" + name
+	costumes, err := c.costumes(dir, t)
+	if err != nil {
+		return err
+	}
+	c.sprites = append(c.sprites, dir)
+	return c.addJSON(dir+"/index.json", map[string]interface{}{
+		"costumes":     costumes,
+		"costumeIndex": t.CurrentCostume,
+		"heading":      t.Direction,
+		"x":            t.X,
+		"y":            t.Y,
+		"size":         t.Size / 100,
+		"visible":      t.Visible,
+		"isDraggable":  t.Draggable,
+	})
+}
+
+func (c *sb3Converter) addSound(name string, s *sb3Asset) error {
+	dir := "assets/sounds/" + name
+	file, data, err := c.asset(s, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+	c.out.AddFile(path.Join(dir, file), data)
+	c.sounds = append(c.sounds, dir)
+	return c.addJSON(dir+"/index.json", map[string]interface{}{
+		"path":        file,
+		"rate":        s.Rate,
+		"sampleCount": s.SampleCount,
+	})
+}
+
+// addStage adds the backdrops of the stage and the z-order of sprites.
+func (c *sb3Converter) addStage(stage *sb3Target, sprites []*sb3Target) error {
+	backdrops, err := c.costumes("assets", stage)
+	if err != nil {
+		return err
+	}
+	zorder := make([]string, len(sprites))
+	for i, t := range sprites {
+		zorder[i] = c.spriteNames[t.Name]
+	}
+	return c.addJSON("assets/index.json", map[string]interface{}{
+		"backdrops":     backdrops,
+		"backdropIndex": stage.CurrentCostume,
+		"map":           map[string]interface{}{"width": 480, "height": 360},
+		"zorder":        zorder,
+	})
+}
+
+func (c *sb3Converter) addJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	c.out.AddFile(name, append(data, '\n'))
+	return nil
+}
+
+// report returns the unsupported blocks, by sprite and opcode.
+func (c *sb3Converter) report() []UnsupportedBlock {
+	res := []UnsupportedBlock{}
+	for b, n := range c.unsupported {
+		b.Count = n
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Sprite != res[j].Sprite {
+			return res[i].Sprite < res[j].Sprite
+		}
+		return res[i].Opcode < res[j].Opcode
+	})
+	return res
+}
+
+// spxName turns a Scratch name into an identifier not yet in taken, and
+// takes it. Characters that can't appear in an identifier become
+// underscores, and names that are empty, start with a digit or are Go
+// keywords get prefix.
+func spxName(name, prefix string, taken map[string]bool) string {
+	id := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	if id == "" || unicode.IsDigit([]rune(id)[0]) || token.IsKeyword(id) {
+		id = prefix + id
+	}
+	for i, base := 2, id; taken[id]; i++ {
+		id = base + strconv.Itoa(i)
+	}
+	taken[id] = true
+	return id
+}
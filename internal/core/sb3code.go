@@ -0,0 +1,492 @@
+package core
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sb3Calls are the Scratch statements that translate to a call of an
+// spx function with the given inputs as arguments.
+var sb3Calls = map[string]struct {
+	fn     string
+	inputs []string
+}{
+	"motion_movesteps":          {"step", []string{"STEPS"}},
+	"motion_turnright":          {"turn", []string{"DEGREES"}},
+	"motion_gotoxy":             {"setXYpos", []string{"X", "Y"}},
+	"motion_changexby":          {"changeXpos", []string{"DX"}},
+	"motion_changeyby":          {"changeYpos", []string{"DY"}},
+	"motion_setx":               {"setXpos", []string{"X"}},
+	"motion_sety":               {"setYpos", []string{"Y"}},
+	"motion_pointindirection":   {"setHeading", []string{"DIRECTION"}},
+	"motion_ifonedgebounce":     {"bounceOffEdge", nil},
+	"looks_say":                 {"say", []string{"MESSAGE"}},
+	"looks_sayforsecs":          {"say", []string{"MESSAGE", "SECS"}},
+	"looks_think":               {"think", []string{"MESSAGE"}},
+	"looks_thinkforsecs":        {"think", []string{"MESSAGE", "SECS"}},
+	"looks_show":                {"show", nil},
+	"looks_hide":                {"hide", nil},
+	"looks_nextcostume":         {"nextCostume", nil},
+	"looks_nextbackdrop":        {"nextBackdrop", nil},
+	"sound_stopallsounds":       {"stopAllSounds", nil},
+	"event_broadcast":           {"broadcast", []string{"BROADCAST_INPUT"}},
+	"control_wait":              {"wait", []string{"DURATION"}},
+	"control_delete_this_clone": {"destroy", nil},
+}
+
+// sb3Operators are the Scratch reporters that translate to a binary
+// operator, along with the names of their operands.
+var sb3Operators = map[string]struct{ op, x, y string }{
+	"operator_add":      {"+", "NUM1", "NUM2"},
+	"operator_subtract": {"-", "NUM1", "NUM2"},
+	"operator_multiply": {"*", "NUM1", "NUM2"},
+	"operator_divide":   {"/", "NUM1", "NUM2"},
+	"operator_gt":       {">", "OPERAND1", "OPERAND2"},
+	"operator_lt":       {"<", "OPERAND1", "OPERAND2"},
+	"operator_equals":   {"==", "OPERAND1", "OPERAND2"},
+	"operator_and":      {"&&", "OPERAND1", "OPERAND2"},
+	"operator_or":       {"||", "OPERAND1", "OPERAND2"},
+}
+
+// sb3Reporters are the Scratch reporters that translate to an spx
+// function without arguments.
+var sb3Reporters = map[string]string{
+	"motion_xposition":  "xpos",
+	"motion_yposition":  "ypos",
+	"motion_direction":  "heading",
+	"sensing_mousedown": "mousePressed",
+}
+
+// sb3Keys are the Scratch keys that aren't a letter or a digit.
+var sb3Keys = map[string]string{
+	"space":       "KeySpace",
+	"enter":       "KeyEnter",
+	"up arrow":    "KeyUp",
+	"down arrow":  "KeyDown",
+	"left arrow":  "KeyLeft",
+	"right arrow": "KeyRight",
+}
+
+// spxKey returns the spx constant of a Scratch key.
+func spxKey(key string) (string, bool) {
+	if k, ok := sb3Keys[key]; ok {
+		return k, true
+	}
+	if len(key) == 1 && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= '0' && key[0] <= '9') {
+		return "Key" + strings.ToUpper(key), true
+	}
+	return "", false
+}
+
+// Bounds on the code translated from a sprite, as a project.json may
+// link its blocks any way it likes.
+const (
+	maxSb3Depth  = 64    // max nesting of bodies, and of reporters
+	maxSb3Blocks = 10000 // max number of statements
+)
+
+// An sb3Script translates the scripts of a Scratch sprite into spx code.
+type sb3Script struct {
+	c      *sb3Converter
+	b      *strings.Builder
+	sprite string               // spx name of the sprite
+	sounds map[string]string    // Scratch sound name to spx name
+	blocks map[string]*sb3Block // by ID
+
+	visited    map[string]bool // blocks of the current script translated so far
+	statements int             // translated so far, up to maxSb3Blocks
+	exprDepth  int             // of the reporter being translated
+}
+
+// translate writes the scripts of t, the sprite named sprite in spx, to
+// b. Stacks of blocks that don't start with an event are never run by
+// Scratch and are left out.
+func (c *sb3Converter) translate(b *strings.Builder, sprite string, t *sb3Target, sounds map[string]string) {
+	s := &sb3Script{c: c, b: b, sprite: sprite, sounds: sounds, blocks: make(map[string]*sb3Block)}
+	var tops []string
+	for id, raw := range t.Blocks {
+		blk := new(sb3Block)
+		if json.Unmarshal(raw, blk) != nil {
+			continue // a variable reporter dropped on the workspace
+		}
+		s.blocks[id] = blk
+		if blk.TopLevel {
+			tops = append(tops, id)
+		}
+	}
+	sort.Slice(tops, func(i, j int) bool {
+		a, b := s.blocks[tops[i]], s.blocks[tops[j]]
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		if a.X != b.X {
+			return a.X < b.X
+		}
+		return tops[i] < tops[j]
+	})
+	for _, id := range tops {
+		blk := s.blocks[id]
+		if !strings.HasPrefix(blk.Opcode, "event_when") && blk.Opcode != "control_start_as_clone" && blk.Opcode != "procedures_definition" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		event, ok := s.event(blk)
+		if !ok {
+			s.unsupported(blk.Opcode)
+			s.line(0, "// TODO: "+blk.Opcode)
+			continue
+		}
+		s.line(0, event+" => {")
+		s.visited = map[string]bool{id: true}
+		s.stack(blk.Next, 1)
+		s.line(0, "}")
+	}
+}
+
+func (s *sb3Script) line(depth int, text string) {
+	s.b.WriteString(strings.Repeat("\t", depth) + text + "\n")
+}
+
+func (s *sb3Script) unsupported(opcode string) {
+	s.c.unsupported[UnsupportedBlock{Sprite: s.sprite, Opcode: opcode}]++
+}
+
+// event returns the spx event handler a hat block translates to, up to
+// its function literal.
+func (s *sb3Script) event(blk *sb3Block) (string, bool) {
+	switch blk.Opcode {
+	case "event_whenflagclicked":
+		return "onStart", true
+	case "event_whenthisspriteclicked", "event_whenstageclicked":
+		return "onClick", true
+	case "control_start_as_clone":
+		return "onCloned", true
+	case "event_whenbroadcastreceived":
+		return "onMsg " + strconv.Quote(blk.field("BROADCAST_OPTION")) + ",", true
+	case "event_whenkeypressed":
+		key := blk.field("KEY_OPTION")
+		if key == "any" {
+			return "onAnyKey key", true
+		}
+		k, ok := spxKey(key)
+		return "onKey " + k + ",", ok
+	}
+	return "", false
+}
+
+// stack translates the blocks from id on. A block met again, through
+// a next or a body linking back, becomes a TODO comment, and so do the
+// blocks past maxSb3Depth or maxSb3Blocks.
+func (s *sb3Script) stack(id string, depth int) {
+	for id != "" {
+		blk := s.blocks[id]
+		if blk == nil {
+			return
+		}
+		switch {
+		case s.visited[id]:
+			s.line(depth, "// TODO: "+blk.Opcode+" already translated")
+			return
+		case depth > maxSb3Depth:
+			s.line(depth, "// TODO: blocks nested too deep")
+			return
+		case s.statements >= maxSb3Blocks:
+			s.line(depth, "// TODO: too many blocks")
+			return
+		}
+		s.visited[id] = true
+		s.statements++
+		s.statement(blk, depth)
+		id = blk.Next
+	}
+}
+
+// statement translates blk. Blocks that can't be translated become TODO
+// comments; the bodies of loops and conditions still are, but never run.
+func (s *sb3Script) statement(blk *sb3Block, depth int) {
+	switch blk.Opcode {
+	case "control_forever":
+		s.body(blk, depth, "forever =>", true, "SUBSTACK")
+		return
+	case "control_repeat":
+		times, ok := s.value(blk, "TIMES")
+		if !ok {
+			times = "0"
+		}
+		s.body(blk, depth, "repeat "+times+", =>", ok, "SUBSTACK")
+		return
+	case "control_repeat_until":
+		cond, ok := s.cond(blk, "CONDITION")
+		if !ok {
+			cond = "true"
+		}
+		s.body(blk, depth, "repeatUntil "+cond+", =>", ok, "SUBSTACK")
+		return
+	case "control_if", "control_if_else":
+		cond, ok := s.cond(blk, "CONDITION")
+		if !ok {
+			cond = "false"
+		}
+		s.line(depth, "if "+cond+" {"+todo(blk, ok))
+		s.stack(s.substack(blk, "SUBSTACK"), depth+1)
+		if blk.Opcode == "control_if_else" {
+			s.line(depth, "} else {")
+			s.stack(s.substack(blk, "SUBSTACK2"), depth+1)
+		}
+		s.line(depth, "}")
+		return
+	}
+	code, ok := s.call(blk)
+	if !ok {
+		s.line(depth, "// TODO: "+blk.Opcode)
+		return
+	}
+	s.line(depth, code)
+}
+
+// todo returns the comment marking the header of a loop or condition
+// that couldn't be translated.
+func todo(blk *sb3Block, ok bool) string {
+	if ok {
+		return ""
+	}
+	return " // TODO: " + blk.Opcode
+}
+
+// body writes a block with a body, such as a loop. Unless ok, its
+// header is marked as not translated.
+func (s *sb3Script) body(blk *sb3Block, depth int, header string, ok bool, input string) {
+	s.line(depth, header+" {"+todo(blk, ok))
+	s.stack(s.substack(blk, input), depth+1)
+	s.line(depth, "}")
+}
+
+// call translates a statement without a body.
+func (s *sb3Script) call(blk *sb3Block) (string, bool) {
+	if call, ok := sb3Calls[blk.Opcode]; ok {
+		args := make([]string, len(call.inputs))
+		for i, input := range call.inputs {
+			if args[i], ok = s.value(blk, input); !ok {
+				return "", false
+			}
+		}
+		if len(args) == 0 {
+			return call.fn, true
+		}
+		return call.fn + " " + strings.Join(args, ", "), true
+	}
+	switch blk.Opcode {
+	case "motion_turnleft":
+		v, ok := s.value(blk, "DEGREES")
+		return "turn " + negate(v), ok
+	case "looks_changesizeby":
+		v, ok := s.value(blk, "CHANGE")
+		return "changeSize " + percent(v), ok
+	case "looks_setsizeto":
+		v, ok := s.value(blk, "SIZE")
+		return "setSize " + percent(v), ok
+	case "looks_switchcostumeto":
+		v, ok := s.menu(blk, "COSTUME", "COSTUME")
+		return "setCostume " + strconv.Quote(v), ok
+	case "looks_switchbackdropto":
+		v, ok := s.menu(blk, "BACKDROP", "BACKDROP")
+		return "startBackdrop " + strconv.Quote(v), ok
+	case "sound_play", "sound_playuntildone":
+		v, ok := s.menu(blk, "SOUND_MENU", "SOUND_MENU")
+		if !ok {
+			return "", false
+		}
+		sound, ok := s.sounds[v]
+		if !ok {
+			break
+		}
+		if blk.Opcode == "sound_playuntildone" {
+			return "play " + sound + ", true", true
+		}
+		return "play " + sound, true
+	case "event_broadcastandwait":
+		v, ok := s.value(blk, "BROADCAST_INPUT")
+		return "broadcast " + v + ", true", ok
+	case "control_create_clone_of":
+		v, ok := s.menu(blk, "CLONE_OPTION", "CLONE_OPTION")
+		if !ok {
+			return "", false
+		}
+		if v == "_myself_" {
+			return "clone", true
+		}
+	}
+	s.unsupported(blk.Opcode)
+	return "", false
+}
+
+// negate returns the opposite of the numeric expression v.
+func negate(v string) string {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return formatNumber(-f)
+	}
+	return "-(" + v + ")"
+}
+
+// percent turns the numeric expression v, a percentage in Scratch, into
+// the ratio spx expects.
+func percent(v string) string {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return formatNumber(f / 100)
+	}
+	return "(" + v + ") / 100"
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// input returns the block or literal given as input name of blk: a block
+// ID, or an array holding the type and value of a literal.
+func (s *sb3Script) input(blk *sb3Block, name string) json.RawMessage {
+	if in := blk.Inputs[name]; len(in) >= 2 {
+		return in[1]
+	}
+	return nil
+}
+
+// substack returns the ID of the first block of body name of blk.
+func (s *sb3Script) substack(blk *sb3Block, name string) string {
+	var id string
+	json.Unmarshal(s.input(blk, name), &id)
+	return id
+}
+
+// menu returns the option picked in the menu given as input name of
+// blk. Menus are blocks of their own, with a field holding the option.
+func (s *sb3Script) menu(blk *sb3Block, name, field string) (string, bool) {
+	m := s.blocks[s.substack(blk, name)]
+	if m == nil {
+		return "", false
+	}
+	if _, ok := m.Fields[field]; !ok {
+		s.unsupported(m.Opcode) // a reporter dropped on the menu
+		return "", false
+	}
+	return m.field(field), true
+}
+
+// value translates input name of blk.
+func (s *sb3Script) value(blk *sb3Block, name string) (string, bool) {
+	in := s.input(blk, name)
+	if in == nil {
+		return "0", true
+	}
+	var id string
+	if json.Unmarshal(in, &id) == nil {
+		if s.visited[id] || s.exprDepth >= maxSb3Depth {
+			return "", false
+		}
+		s.visited[id] = true
+		s.exprDepth++
+		defer func() { s.exprDepth-- }()
+		return s.expr(s.blocks[id])
+	}
+	var lit []interface{}
+	if json.Unmarshal(in, &lit) != nil || len(lit) < 2 {
+		return "0", true
+	}
+	typ, _ := lit[0].(float64)
+	text := ""
+	switch v := lit[1].(type) {
+	case string:
+		text = v
+	case float64:
+		text = formatNumber(v)
+	}
+	switch typ {
+	case 4, 5, 6, 7, 8: // numbers and angles
+		if text == "" {
+			return "0", true
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return formatNumber(f), true
+		}
+	case 12:
+		s.unsupported("data_variable")
+		return "", false
+	case 13:
+		s.unsupported("data_listcontents")
+		return "", false
+	}
+	return strconv.Quote(text), true
+}
+
+// operand translates input name of blk, in parentheses if it's an
+// operation itself.
+func (s *sb3Script) operand(blk *sb3Block, name string) (string, bool) {
+	v, ok := s.value(blk, name)
+	var id string
+	if json.Unmarshal(s.input(blk, name), &id) == nil && s.blocks[id] != nil {
+		if _, op := sb3Operators[s.blocks[id].Opcode]; op {
+			v = "(" + v + ")"
+		}
+	}
+	return v, ok
+}
+
+// cond translates the condition given as input name of blk. Scratch
+// treats an empty condition as false.
+func (s *sb3Script) cond(blk *sb3Block, name string) (string, bool) {
+	if s.input(blk, name) == nil {
+		return "false", true
+	}
+	return s.value(blk, name)
+}
+
+// expr translates a reporter block.
+func (s *sb3Script) expr(blk *sb3Block) (string, bool) {
+	if blk == nil {
+		return "", false
+	}
+	if fn, ok := sb3Reporters[blk.Opcode]; ok {
+		return fn, true
+	}
+	if op, ok := sb3Operators[blk.Opcode]; ok {
+		x, okx := s.operand(blk, op.x)
+		y, oky := s.operand(blk, op.y)
+		return x + " " + op.op + " " + y, okx && oky
+	}
+	switch blk.Opcode {
+	case "operator_not":
+		x, ok := s.operand(blk, "OPERAND")
+		return "!" + x, ok
+	case "operator_random":
+		from, okf := s.value(blk, "FROM")
+		to, okt := s.value(blk, "TO")
+		return "rand(" + from + ", " + to + ")", okf && okt
+	case "sensing_keypressed":
+		v, ok := s.menu(blk, "KEY_OPTION", "KEY_OPTION")
+		if !ok {
+			return "", false
+		}
+		if key, ok := spxKey(v); ok {
+			return "keyPressed(" + key + ")", true
+		}
+	case "sensing_touchingobject":
+		v, ok := s.menu(blk, "TOUCHINGOBJECTMENU", "TOUCHINGOBJECTMENU")
+		if !ok {
+			return "", false
+		}
+		switch v {
+		case "_edge_":
+			return "touching(Edge)", true
+		case "_mouse_":
+			return "touching(Mouse)", true
+		}
+		if name, ok := s.c.spriteNames[v]; ok {
+			return "touching(" + strconv.Quote(name) + ")", true
+		}
+	}
+	s.unsupported(blk.Opcode)
+	return "", false
+}
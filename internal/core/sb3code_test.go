@@ -0,0 +1,72 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTranslateCycles(t *testing.T) {
+	translate := func(blocks map[string]string) string {
+		t.Helper()
+		target := &sb3Target{Blocks: make(map[string]json.RawMessage)}
+		for id, blk := range blocks {
+			target.Blocks[id] = json.RawMessage(blk)
+		}
+		c := &sb3Converter{spriteNames: map[string]string{}, unsupported: map[UnsupportedBlock]int{}}
+		var b strings.Builder
+		c.translate(&b, "Cat", target, nil)
+		return b.String()
+	}
+	const hat = `{"opcode": "event_whenflagclicked", "next": "b", "topLevel": true}`
+
+	tests := []struct {
+		name   string
+		blocks map[string]string
+		want   string
+	}{
+		{"next", map[string]string{
+			"a": hat,
+			"b": `{"opcode": "looks_show", "next": "c"}`,
+			"c": `{"opcode": "looks_hide", "next": "b"}`,
+		}, "onStart => {\n\tshow\n\thide\n\t// TODO: looks_show already translated\n}\n"},
+		{"hat", map[string]string{
+			"a": hat,
+			"b": `{"opcode": "looks_show", "next": "a"}`,
+		}, "onStart => {\n\tshow\n\t// TODO: event_whenflagclicked already translated\n}\n"},
+		{"substack", map[string]string{
+			"a": hat,
+			"b": `{"opcode": "control_forever", "inputs": {"SUBSTACK": [2, "b"]}}`,
+		}, "onStart => {\n\tforever => {\n\t\t// TODO: control_forever already translated\n\t}\n}\n"},
+		{"reporter", map[string]string{
+			"a": hat,
+			"b": `{"opcode": "motion_movesteps", "inputs": {"STEPS": [3, "r"]}}`,
+			"r": `{"opcode": "operator_add", "inputs": {"NUM1": [3, "r"], "NUM2": [1, [4, "1"]]}}`,
+		}, "onStart => {\n\t// TODO: motion_movesteps\n}\n"},
+	}
+	for _, tt := range tests {
+		if got := translate(tt.blocks); got != tt.want {
+			t.Errorf("%s: translated to %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	deep := map[string]string{"a": hat}
+	for i := 0; i < 2*maxSb3Depth; i++ {
+		deep[fmt.Sprint("b", i)] = fmt.Sprintf(`{"opcode": "control_forever", "inputs": {"SUBSTACK": [2, "b%d"]}}`, i+1)
+	}
+	deep["b"] = deep["b0"]
+	if got := translate(deep); !strings.Contains(got, "// TODO: blocks nested too deep") {
+		t.Errorf("deep nesting translated to %d bytes without a TODO", len(got))
+	}
+
+	long := map[string]string{"a": hat}
+	for i := 0; i <= maxSb3Blocks; i++ {
+		long[fmt.Sprint("b", i)] = fmt.Sprintf(`{"opcode": "looks_show", "next": "b%d"}`, i+1)
+	}
+	long["b"] = long["b0"]
+	got := translate(long)
+	if n := strings.Count(got, "\tshow\n"); n != maxSb3Blocks || !strings.HasSuffix(got, "\t// TODO: too many blocks\n}\n") {
+		t.Errorf("long script translated to %d statements, ending in %q", n, got[len(got)-40:])
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"gocloud.dev/gcerrors"
+)
+
+// Serve serves h on conf.ListenAddr until ctx is done. It then stops
+// accepting connections and waits up to conf.ShutdownTimeout for
+// in-flight requests, such as uploads, to finish.
+func Serve(ctx context.Context, conf *Config, h http.Handler) error {
+	srv := &http.Server{Addr: conf.ListenAddr, Handler: h}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// maxFormMemory is how much of a multipart form is kept in memory, the
+// rest going to temporary files, as with Request.FormValue.
+const maxFormMemory = 32 << 20
+
+// maxFormOverhead is what a request body may take beyond the files it
+// carries: the other form fields and the multipart framing.
+const maxFormOverhead = 1 << 20
+
+// ParseForms wraps h to parse the form of requests up front. yap stores
+// path parameters in Request.Form, after which FormValue no longer reads
+// the body, so the fields of a multipart body sent to a route with path
+// parameters would be lost. Bodies larger than a project may be once
+// unpacked, see Config.MaxUnpackedMB, are rejected with a 413 before
+// they are buffered; other malformed bodies are left to h.
+func (p *Project) ParseForms(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(p.limits.size) + maxFormOverhead
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		var maxErr *http.MaxBytesError
+		if err := r.ParseMultipartForm(maxFormMemory); errors.As(err, &maxErr) {
+			size := min(max(r.ContentLength, limit+1), math.MaxInt32)
+			writeError(w, r, &LimitError{What: "request size", Value: int(size), Limit: int(limit)})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeError answers r with the status and body a handler would give
+// err, for middlewares that reject a request before it reaches one.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := ErrorStatus(r.Context(), err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorBody(code, err))
+}
+
+// readyKey is looked up by Ready to check that the bucket answers. It
+// doesn't need to exist.
+const readyKey = ".readyz"
+
+// Ready reports whether the database and the bucket are reachable.
+func (p *Project) Ready(ctx context.Context) error {
+	if err := p.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if _, err := p.bucket.Attributes(ctx, readyKey); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return fmt.Errorf("bucket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// AssetSprite is the AssetType of sprites, whose index.json is checked
+// and whose costumes are packed into an atlas when they are uploaded.
+const AssetSprite = "sprite"
+
+// maxAtlasSize is the max width and height of a sprite atlas. Sprites
+// whose costumes don't fit are stored without one.
+const maxAtlasSize = 4096
+
+// maxSpritePixels is the max number of pixels of all the costumes of a
+// sprite together, checked before any of them is decoded.
+const maxSpritePixels = maxAtlasSize * maxAtlasSize
+
+// atlasPadding is the gap between costumes in an atlas, so filtering
+// one doesn't bleed into its neighbours.
+const atlasPadding = 1
+
+// A SpriteAtlas is the costumes of a sprite packed into a single PNG, so
+// a client loads the sprite with one request.
+type SpriteAtlas struct {
+	Image    string       `json:"image"` // URL of the PNG
+	Width    int          `json:"width"`
+	Height   int          `json:"height"`
+	Costumes []AtlasFrame `json:"costumes"` // in the order of index.json
+}
+
+// An AtlasFrame locates a costume in a SpriteAtlas.
+type AtlasFrame struct {
+	Name             string  `json:"name"`
+	Path             string  `json:"path"` // the file of the costume
+	X                int     `json:"x"`    // of the top left corner, in pixels
+	Y                int     `json:"y"`
+	Width            int     `json:"width"` // of the costume image, in pixels
+	Height           int     `json:"height"`
+	RotationCenterX  float64 `json:"rotationCenterX"` // from index.json
+	RotationCenterY  float64 `json:"rotationCenterY"`
+	BitmapResolution float64 `json:"bitmapResolution"`
+}
+
+// spriteIndex is the part of the index.json of a sprite that is checked.
+type spriteIndex struct {
+	Costumes     []spxCostume `json:"costumes"`
+	CostumeIndex int          `json:"costumeIndex"`
+}
+
+// spriteFiles are the files of a sprite asset being uploaded, with its
+// costumes decoded.
+type spriteFiles struct {
+	index  spriteIndex
+	images map[string]image.Image // costume file to its image
+}
+
+// checkSprite checks the index.json of sprite files fs: it must list at
+// least one costume, each with a unique name, an image among fs and a
+// rotation center inside that image. The images, at most maxSpritePixels
+// in all, are then decoded.
+func checkSprite(fs *fileSet) (*spriteFiles, error) {
+	const index = "index.json"
+	if !fs.Contains(index) {
+		return nil, &FileError{Name: index, Reason: "missing file"}
+	}
+	s := &spriteFiles{images: make(map[string]image.Image)}
+	if err := json.Unmarshal(fs.Data(index), &s.index); err != nil {
+		return nil, &FileError{Name: index, Reason: err.Error()}
+	}
+	costumes := s.index.Costumes
+	if len(costumes) == 0 {
+		return nil, &FileError{Name: index, Reason: "no costumes"}
+	}
+	if i := s.index.CostumeIndex; i < 0 || i >= len(costumes) {
+		return nil, &FileError{Name: index, Reason: fmt.Sprintf("costumeIndex %d out of range", i)}
+	}
+	names := make(map[string]bool)
+	sizes := make(map[string]image.Point)
+	pixels := 0
+	for i := range costumes {
+		c := &costumes[i]
+		switch {
+		case c.Name == "":
+			return nil, &FileError{Name: index, Reason: fmt.Sprintf("costume %d has no name", i)}
+		case names[c.Name]:
+			return nil, &FileError{Name: index, Reason: fmt.Sprintf("duplicate costume %q", c.Name)}
+		case c.BitmapResolution < 0:
+			return nil, &FileError{Name: index, Reason: fmt.Sprintf("negative bitmapResolution of costume %q", c.Name)}
+		case !fs.Contains(c.Path):
+			return nil, &FileError{Name: c.Path, Reason: fmt.Sprintf("missing file of costume %q", c.Name)}
+		case !thumbnailImages[strings.ToLower(path.Ext(c.Path))]:
+			return nil, &FileError{Name: c.Path, Reason: "unsupported image format"}
+		}
+		names[c.Name] = true
+		if c.BitmapResolution == 0 {
+			c.BitmapResolution = 1
+		}
+		size, ok := sizes[c.Path]
+		if !ok {
+			var err error
+			if size, err = costumeSize(c.Path, fs.Data(c.Path)); err != nil {
+				return nil, &FileError{Name: c.Path, Reason: err.Error()}
+			}
+			sizes[c.Path] = size
+			if pixels += size.X * size.Y; pixels > maxSpritePixels {
+				return nil, &LimitError{What: "costume pixels", Value: pixels, Limit: maxSpritePixels}
+			}
+		}
+		if c.X < 0 || c.Y < 0 || c.X > float64(size.X) || c.Y > float64(size.Y) {
+			return nil, &FileError{Name: index, Reason: fmt.Sprintf("rotation center of costume %q outside its %dx%d image", c.Name, size.X, size.Y)}
+		}
+	}
+	for _, c := range costumes {
+		if s.images[c.Path] != nil {
+			continue
+		}
+		img, err := decodeCostume(c.Path, fs.Data(c.Path))
+		if err != nil {
+			return nil, &FileError{Name: c.Path, Reason: err.Error()}
+		}
+		s.images[c.Path] = img
+	}
+	return s, nil
+}
+
+// costumeSize returns the size in pixels decodeCostume decodes the
+// image of a costume at, reading only its header or view box.
+func costumeSize(name string, data []byte) (image.Point, error) {
+	if strings.ToLower(path.Ext(name)) == ".svg" {
+		icon, err := readSVG(data)
+		if err != nil {
+			return image.Point{}, err
+		}
+		w, h := svgCostumeSize(icon.ViewBox.W, icon.ViewBox.H)
+		return image.Pt(w, h), nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Pt(cfg.Width, cfg.Height), nil
+}
+
+// svgCostumeSize is the size of an SVG costume with the given view box.
+func svgCostumeSize(w, h float64) (int, int) {
+	return int(math.Ceil(min(w, 1<<20))), int(math.Ceil(min(h, 1<<20)))
+}
+
+// decodeCostume decodes the image of a costume at its size in pixels;
+// SVG images are rasterized at the size of their view box.
+func decodeCostume(name string, data []byte) (image.Image, error) {
+	if strings.ToLower(path.Ext(name)) == ".svg" {
+		return rasterizeSVG(data, svgCostumeSize)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailSource {
+		return nil, &LimitError{What: "image pixels", Value: cfg.Width * cfg.Height, Limit: maxThumbnailSource}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// spriteAtlas packs the costumes of s into an atlas and stores it. Like
+// thumbnails, atlases are a nicety: a sprite whose costumes don't fit in
+// one is stored without it.
+func (p *Project) spriteAtlas(ctx context.Context, s *spriteFiles) *SpriteAtlas {
+	files := make([]string, 0, len(s.images))
+	for name := range s.images {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	sizes := make([]image.Point, len(files))
+	for i, name := range files {
+		sizes[i] = s.images[name].Bounds().Size()
+	}
+	at, size := packAtlas(sizes)
+	if size.X > maxAtlasSize || size.Y > maxAtlasSize {
+		p.log.WarnContext(ctx, "sprite atlas not packed", "width", size.X, "height", size.Y)
+		return nil
+	}
+	dst := image.NewNRGBA(image.Rectangle{Max: size})
+	pos := make(map[string]image.Point)
+	for i, name := range files {
+		img := s.images[name]
+		draw.Draw(dst, image.Rectangle{Min: at[i], Max: at[i].Add(sizes[i])}, img, img.Bounds().Min, draw.Src)
+		pos[name] = at[i]
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		p.log.WarnContext(ctx, "sprite atlas not encoded", "err", err)
+		return nil
+	}
+	key := p.conf.SpiritPath + "atlases/" + contentHash(buf.Bytes()) + ".png"
+	if ok, err := p.blobExists(ctx, key); err != nil || !ok {
+		if err = p.writeBlob(ctx, key, buf.Bytes()); err != nil {
+			p.log.WarnContext(ctx, "sprite atlas not stored", "err", err)
+			return nil
+		}
+	}
+	atlas := &SpriteAtlas{Image: key, Width: size.X, Height: size.Y}
+	for _, c := range s.index.Costumes {
+		b := s.images[c.Path].Bounds()
+		atlas.Costumes = append(atlas.Costumes, AtlasFrame{
+			Name:             c.Name,
+			Path:             c.Path,
+			X:                pos[c.Path].X,
+			Y:                pos[c.Path].Y,
+			Width:            b.Dx(),
+			Height:           b.Dy(),
+			RotationCenterX:  c.X,
+			RotationCenterY:  c.Y,
+			BitmapResolution: c.BitmapResolution,
+		})
+	}
+	return atlas
+}
+
+// packAtlas places images of the given sizes on shelves, tallest first,
+// in an atlas about as wide as it is high. It returns the top left
+// corner of each image and the size of the atlas.
+func packAtlas(sizes []image.Point) ([]image.Point, image.Point) {
+	order := make([]int, len(sizes))
+	area, width := 0, 0
+	for i, s := range sizes {
+		order[i] = i
+		area += (s.X + atlasPadding) * (s.Y + atlasPadding)
+		width = max(width, s.X)
+	}
+	width = max(width, int(math.Ceil(math.Sqrt(float64(area)))))
+	sort.SliceStable(order, func(a, b int) bool { return sizes[order[a]].Y > sizes[order[b]].Y })
+
+	at := make([]image.Point, len(sizes))
+	var size image.Point
+	x, y, shelf := 0, 0, 0
+	for _, i := range order {
+		s := sizes[i]
+		if x > 0 && x+s.X > width {
+			x, y, shelf = 0, y+shelf+atlasPadding, 0
+		}
+		at[i] = image.Pt(x, y)
+		x += s.X + atlasPadding
+		shelf = max(shelf, s.Y)
+		size.X = max(size.X, x-atlasPadding)
+		size.Y = max(size.Y, y+s.Y)
+	}
+	return at, size
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"image"
+	"testing"
+)
+
+func TestPackAtlas(t *testing.T) {
+	tests := [][]image.Point{
+		{{64, 64}},
+		{{10, 10}, {10, 10}, {10, 10}, {10, 10}},
+		{{300, 20}, {20, 300}, {50, 50}, {1, 1}, {120, 80}, {80, 120}},
+		{},
+	}
+	for _, sizes := range tests {
+		at, size := packAtlas(sizes)
+		bounds := image.Rectangle{Max: size}
+		var used image.Rectangle
+		for i, s := range sizes {
+			r := image.Rectangle{Min: at[i], Max: at[i].Add(s)}
+			if !r.In(bounds) {
+				t.Errorf("%v: %v outside the %v atlas", sizes, r, size)
+			}
+			for j := 0; j < i; j++ {
+				if r.Overlaps(image.Rectangle{Min: at[j], Max: at[j].Add(sizes[j])}) {
+					t.Errorf("%v: images %d and %d overlap", sizes, j, i)
+				}
+			}
+			used = used.Union(r)
+		}
+		if used.Size() != size {
+			t.Errorf("%v: atlas is %v, images span %v", sizes, size, used.Size())
+		}
+	}
+
+	at, size := packAtlas([]image.Point{{10, 10}, {10, 10}, {10, 10}, {10, 10}})
+	if size != image.Pt(21, 21) || at[3] != image.Pt(11, 11) {
+		t.Errorf("four squares packed in %v at %v", size, at)
+	}
+}
@@ -0,0 +1,288 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/draw"
+)
+
+// Bounds of a thumbnail, the size of the stage scaled down. Images are
+// scaled to fit, keeping their aspect ratio, and never scaled up.
+const (
+	thumbnailWidth  = 320
+	thumbnailHeight = 240
+)
+
+// maxThumbnailSource is the max number of pixels of an image a
+// thumbnail or a sprite atlas is rendered from, so a crafted PNG can't
+// exhaust memory.
+const maxThumbnailSource = 4096 * 4096
+
+// thumbnailImages are the extensions of the images thumbnails are
+// rendered from.
+var thumbnailImages = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true}
+
+// noThumbnail is the thumbnail key of an asset known to have none,
+// because it has no image or its image can't be rendered, so it isn't
+// tried again each time the asset is fetched.
+const noThumbnail = "-"
+
+// thumbnailURL returns the URL of the thumbnail stored under key, or ""
+// if there is none.
+func (p *Project) thumbnailURL(key string) string {
+	if key == "" || key == noThumbnail {
+		return ""
+	}
+	return p.conf.QiniuPath + key
+}
+
+// thumbnailKey is the inverse of thumbnailURL.
+func (p *Project) thumbnailKey(url string) string {
+	return strings.TrimPrefix(url, p.conf.QiniuPath)
+}
+
+// projectThumbnail renders the thumbnail of the project made of fs and
+// returns its URL. Thumbnails are a nicety: a project without an image,
+// or with one that can't be rendered, has none and is saved anyway.
+func (p *Project) projectThumbnail(ctx context.Context, fs *fileSet) string {
+	name := thumbnailSource(fs)
+	if name == "" {
+		return ""
+	}
+	key, err := p.storeThumbnail(ctx, name, fs.Data(name))
+	if err != nil {
+		p.log.WarnContext(ctx, "thumbnail not rendered", "file", name, "err", err)
+		return ""
+	}
+	return p.thumbnailURL(key)
+}
+
+// thumbnailSource returns the image of fs a project is previewed with:
+// the current backdrop of the stage, or else the current costume of the
+// sprite at the back, or else the first image under assets/.
+func thumbnailSource(fs *fileSet) string {
+	var stage struct {
+		Backdrops     []struct{ Path string } `json:"backdrops"`
+		BackdropIndex int                     `json:"backdropIndex"`
+		Scenes        []struct{ Path string } `json:"scenes"` // the name of backdrops in older projects
+		SceneIndex    int                     `json:"sceneIndex"`
+		Zorder        []json.RawMessage       `json:"zorder"` // sprite names, among other things
+	}
+	json.Unmarshal(fs.Data("assets/index.json"), &stage)
+	if name := pick(fs, "assets", stage.Backdrops, stage.BackdropIndex); name != "" {
+		return name
+	}
+	if name := pick(fs, "assets", stage.Scenes, stage.SceneIndex); name != "" {
+		return name
+	}
+	for _, z := range stage.Zorder {
+		var sprite string
+		if json.Unmarshal(z, &sprite) != nil {
+			continue
+		}
+		dir := path.Join("assets/sprites", sprite)
+		var index struct {
+			Costumes     []struct{ Path string } `json:"costumes"`
+			CostumeIndex int                     `json:"costumeIndex"`
+		}
+		json.Unmarshal(fs.Data(dir+"/index.json"), &index)
+		if name := pick(fs, dir, index.Costumes, index.CostumeIndex); name != "" {
+			return name
+		}
+	}
+	names := append([]string(nil), fs.files...)
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.HasPrefix(name, "assets/") && thumbnailImages[strings.ToLower(path.Ext(name))] {
+			return name
+		}
+	}
+	return ""
+}
+
+// pick returns the name of image i of images, relative to dir, if fs
+// has it, falling back to the first image.
+func pick(fs *fileSet, dir string, images []struct{ Path string }, i int) string {
+	if len(images) == 0 {
+		return ""
+	}
+	if i < 0 || i >= len(images) {
+		i = 0
+	}
+	name := path.Join(dir, images[i].Path)
+	if !fs.Contains(name) || !thumbnailImages[strings.ToLower(path.Ext(name))] {
+		return ""
+	}
+	return name
+}
+
+// storeThumbnail renders the thumbnail of image name and stores it under
+// a key derived from its content, so projects sharing a backdrop share
+// its thumbnail.
+func (p *Project) storeThumbnail(ctx context.Context, name string, data []byte) (string, error) {
+	thumb, err := renderThumbnail(name, data)
+	if err != nil {
+		return "", err
+	}
+	return p.writeThumbnail(ctx, thumb)
+}
+
+// writeThumbnail stores a rendered thumbnail and returns its key.
+func (p *Project) writeThumbnail(ctx context.Context, thumb []byte) (string, error) {
+	key := p.conf.ProjectPath + "thumbnails/" + contentHash(thumb) + ".png"
+	if ok, err := p.blobExists(ctx, key); err == nil && ok {
+		return key, nil
+	}
+	return key, p.writeBlob(ctx, key, thumb)
+}
+
+// renderThumbnail renders image name, given its content, as a PNG of at
+// most thumbnailWidth by thumbnailHeight pixels.
+func renderThumbnail(name string, data []byte) ([]byte, error) {
+	var dst *image.RGBA
+	if strings.ToLower(path.Ext(name)) == ".svg" {
+		var err error
+		if dst, err = rasterizeSVG(data, fit); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Width*cfg.Height > maxThumbnailSource {
+			return nil, &LimitError{What: "image pixels", Value: cfg.Width * cfg.Height, Limit: maxThumbnailSource}
+		}
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		w, h := fit(float64(cfg.Width), float64(cfg.Height))
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fit returns the size of an image of w by h pixels scaled down to fit a
+// thumbnail.
+func fit(w, h float64) (int, int) {
+	scale := min(1, thumbnailWidth/w, thumbnailHeight/h)
+	return max(1, int(w*scale+0.5)), max(1, int(h*scale+0.5))
+}
+
+// readSVG parses an SVG image, which must have a view box.
+func readSVG(data []byte) (icon *oksvg.SvgIcon, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("svg: %v", r)
+		}
+	}()
+	icon, err = oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, errors.New("svg: no size")
+	}
+	return icon, nil
+}
+
+// rasterizeSVG renders an SVG image at the size returned by size, given
+// that of its view box. Elements the rasterizer doesn't know are skipped.
+func rasterizeSVG(data []byte, size func(w, h float64) (int, int)) (img *image.RGBA, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("svg: %v", r)
+		}
+	}()
+	icon, err := readSVG(data)
+	if err != nil {
+		return nil, err
+	}
+	w, h := size(icon.ViewBox.W, icon.ViewBox.H)
+	if w*h > maxThumbnailSource {
+		return nil, &LimitError{What: "image pixels", Value: w * h, Limit: maxThumbnailSource}
+	}
+	img = image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	icon.Draw(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, img.Bounds())), 1)
+	return img, nil
+}
+
+// assetThumbnail renders the thumbnail of asset a from its first
+// costume, or else its first image, and records it. Assets without an
+// image, such as sounds, or whose image can't be rendered, are recorded
+// as having none, see noThumbnail. Only bucket failures leave the
+// thumbnail to be tried again when the asset is next fetched.
+func (p *Project) assetThumbnail(ctx context.Context, a *Asset) {
+	key := noThumbnail
+	name, data, err := p.assetImage(ctx, a)
+	if err != nil {
+		p.log.WarnContext(ctx, "thumbnail source not read", "asset", a.ID, "file", name, "err", err)
+		return
+	}
+	if name != "" {
+		thumb, err := renderThumbnail(name, data)
+		if err != nil {
+			p.log.WarnContext(ctx, "thumbnail not rendered", "asset", a.ID, "file", name, "err", err)
+		} else if key, err = p.writeThumbnail(ctx, thumb); err != nil {
+			p.log.WarnContext(ctx, "thumbnail not stored", "asset", a.ID, "err", err)
+			return
+		}
+	}
+	a.Thumbnail = key
+	if _, err = p.db.ExecContext(ctx, "UPDATE asset SET thumbnail = ? WHERE id = ?", key, a.ID); err != nil {
+		p.log.WarnContext(ctx, "thumbnail not recorded", "asset", a.ID, "err", err)
+	}
+}
+
+// assetImage returns the name and content of the image the thumbnail of
+// asset a is rendered from, or "" if it has none.
+func (p *Project) assetImage(ctx context.Context, a *Asset) (string, []byte, error) {
+	var address AssetAddress
+	if json.Unmarshal([]byte(a.Address), &address) != nil {
+		return "", nil, nil
+	}
+	var names []string
+	for name := range address.Assets {
+		if thumbnailImages[strings.ToLower(path.Ext(name))] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil, nil
+	}
+	sort.Strings(names)
+	name := names[0]
+	var index struct {
+		Costumes []struct{ Path string } `json:"costumes"`
+	}
+	if address.IndexJson != "" {
+		data, err := p.readBlob(ctx, address.IndexJson)
+		if err == nil && json.Unmarshal(data, &index) == nil && len(index.Costumes) > 0 {
+			if costume := path.Base(index.Costumes[0].Path); address.Assets[costume] != "" {
+				name = costume
+			}
+		}
+	}
+	data, err := p.readBlob(ctx, address.Assets[name])
+	return name, data, err
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func TestRenderThumbnail(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+	}{
+		{"stage.png", encodePNG(480, 360), 320, 240},
+		{"tall.png", encodePNG(100, 1000), 24, 240},
+		{"small.png", encodePNG(10, 20), 10, 20},
+		{"wide.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 960 360"><rect width="960" height="360" fill="#f00"/></svg>`), 320, 120},
+	}
+	for _, tt := range tests {
+		data, err := renderThumbnail(tt.name, tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("%s: thumbnail is %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.w, tt.h)
+		}
+	}
+
+	svg, _ := renderThumbnail("red.svg", tests[3].data)
+	img, _ := png.Decode(bytes.NewReader(svg))
+	if r, g, _, a := img.At(160, 60).RGBA(); r != 0xffff || g != 0 || a != 0xffff {
+		t.Errorf("svg pixel = %v, want red", img.At(160, 60))
+	}
+
+	for _, name := range []string{"bad.png", "bad.svg"} {
+		if _, err := renderThumbnail(name, []byte("not an image")); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestThumbnailSource(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		want  string
+	}{
+		{map[string]string{
+			"assets/index.json": `{"backdrops": [{"path": "a.png"}, {"path": "b.png"}], "backdropIndex": 1, "zorder": ["Cat"]}`,
+			"assets/a.png":      "",
+			"assets/b.png":      "",
+		}, "assets/b.png"},
+		{map[string]string{
+			"assets/index.json":             `{"scenes": [{"path": "old.jpg"}]}`,
+			"assets/old.jpg":                "",
+			"assets/sprites/Cat/index.json": `{"costumes": [{"path": "cat.png"}]}`,
+		}, "assets/old.jpg"},
+		{map[string]string{
+			"assets/index.json":             `{"backdrops": [{"path": "missing.png"}], "zorder": [{"type": "layer"}, "Cat"]}`,
+			"assets/sprites/Cat/index.json": `{"costumes": [{"path": "a.svg"}, {"path": "b.svg"}], "costumeIndex": 1}`,
+			"assets/sprites/Cat/a.svg":      "",
+			"assets/sprites/Cat/b.svg":      "",
+		}, "assets/sprites/Cat/b.svg"},
+		{map[string]string{
+			"main.spx":             "",
+			"assets/z.png":         "",
+			"assets/sounds/a.wav":  "",
+			"assets/sprites/a.gif": "",
+		}, "assets/sprites/a.gif"},
+		{map[string]string{"main.spx": "", "cover.png": ""}, ""},
+	}
+	for i, tt := range tests {
+		fs := new(fileSet)
+		for name, data := range tt.files {
+			fs.AddFile(name, []byte(data))
+		}
+		if got := thumbnailSource(fs); got != tt.want {
+			t.Errorf("#%d: thumbnailSource = %q, want %q", i, got, tt.want)
+		}
+	}
+}
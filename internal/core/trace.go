@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of this package. It is a no-op until
+// setupTracing installs a real provider.
+var tracer = otel.Tracer("github.com/Mrkuib/spx-back/internal/core")
+
+// setupTracing installs the global tracer provider for exporter, which
+// is one of the TRACE_EXPORTER values. The otlp exporter sends spans over
+// HTTP to the collector named by the standard OTEL_EXPORTER_OTLP_*
+// variables, localhost:4318 by default. The returned function flushes
+// and stops the exporter.
+func setupTracing(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exp, err = stdouttrace.New()
+	case "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("spx-back"),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (p *Project) readBlob(ctx context.Context, key string) (data []byte, err error) {
+	ctx, span := tracer.Start(ctx, "blob.ReadAll", trace.WithAttributes(attribute.String("blob.key", key)))
+	defer func() { endSpan(span, err) }()
+	return p.bucket.ReadAll(ctx, key)
+}
+
+func (p *Project) writeBlob(ctx context.Context, key string, data []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "blob.WriteAll", trace.WithAttributes(
+		attribute.String("blob.key", key),
+		attribute.Int("blob.size", len(data)),
+	))
+	defer func() { endSpan(span, err) }()
+	return p.bucket.WriteAll(ctx, key, data, nil)
+}
+
+func (p *Project) blobExists(ctx context.Context, key string) (ok bool, err error) {
+	ctx, span := tracer.Start(ctx, "blob.Exists", trace.WithAttributes(attribute.String("blob.key", key)))
+	defer func() { endSpan(span, err) }()
+	return p.bucket.Exists(ctx, key)
+}
+
+func (p *Project) deleteBlob(ctx context.Context, key string) (err error) {
+	ctx, span := tracer.Start(ctx, "blob.Delete", trace.WithAttributes(attribute.String("blob.key", key)))
+	defer func() { endSpan(span, err) }()
+	return p.bucket.Delete(ctx, key)
+}
+
+func (p *Project) copyBlob(ctx context.Context, dstKey, srcKey string) (err error) {
+	ctx, span := tracer.Start(ctx, "blob.Copy", trace.WithAttributes(
+		attribute.String("blob.key", dstKey),
+		attribute.String("blob.src_key", srcKey),
+	))
+	defer func() { endSpan(span, err) }()
+	return p.bucket.Copy(ctx, dstKey, srcKey, nil)
+}
@@ -6,10 +6,11 @@ package core
 
 import (
 	"bytes"
-	"errors"
-	"fmt"
+	"encoding/base64"
 	"path"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/tools/txtar"
 )
@@ -48,21 +49,53 @@ func (fs *fileSet) AddFile(filename string, src []byte) {
 	}
 }
 
-// Format returns fs formatted as a txtar archive.
+// RemoveFile removes filename from fs, if present.
+func (fs *fileSet) RemoveFile(filename string) {
+	if !fs.Contains(filename) {
+		return
+	}
+	delete(fs.m, filename)
+	for i, f := range fs.files {
+		if f == filename {
+			fs.files = append(fs.files[:i], fs.files[i+1:]...)
+			break
+		}
+	}
+}
+
+// Format returns fs formatted as a txtar archive. Files that are not
+// text, see isText, are written as base64 sections (see splitFiles).
 func (fs *fileSet) Format() []byte {
 	a := new(txtar.Archive)
-	if fs.noHeader {
+	comment := fs.noHeader && isText(fs.m[progName])
+	if comment {
 		a.Comment = fs.m[progName]
 	}
 	for i, f := range fs.files {
-		if i == 0 && f == progName && fs.noHeader {
+		if i == 0 && f == progName && comment {
 			continue
 		}
-		a.Files = append(a.Files, txtar.File{Name: f, Data: fs.m[f]})
+		data := fs.m[f]
+		if !isText(data) {
+			f, data = f+base64Suffix, encodeBase64(data)
+		}
+		a.Files = append(a.Files, txtar.File{Name: f, Data: data})
 	}
 	return txtar.Format(a)
 }
 
+// base64Suffix marks a txtar section whose content is the base64
+// encoding of a binary file, as in "-- assets/cat.png;base64 --".
+const base64Suffix = ";base64"
+
+// fileLimits bounds what splitFiles and unzipFiles accept.
+type fileLimits struct {
+	numFiles int // max number of files
+	nameLen  int // max length of a file name
+	depth    int // max number of path elements in a file name
+	size     int // max number of bytes of all files, once unpacked
+}
+
 // splitFiles splits the user's input program src into 1 or more
 // files, splitting it based on boundaries as specified by the "txtar"
 // format. It returns an error if any filenames are bogus or
@@ -74,48 +107,112 @@ func (fs *fileSet) Format() []byte {
 // The filenames are validated to only be relative paths, not too
 // long, not too deep, not have ".." elements, not have backslashes or
 // low ASCII binary characters, and to be in path.Clean canonical
-// form.
+// form. Violations of lim are reported as *LimitError, bogus names as
+// *FileError.
+//
+// A section whose name ends in ";base64" holds a binary file; its
+// content is decoded and the suffix dropped from the name.
 //
 // splitFiles takes ownership of src.
-func splitFiles(src []byte) (*fileSet, error) {
+func splitFiles(src []byte, lim fileLimits) (*fileSet, error) {
 	fs := new(fileSet)
 	a := txtar.Parse(src)
 	if v := bytes.TrimSpace(a.Comment); len(v) > 0 {
 		fs.noHeader = true
 		fs.AddFile(progName, a.Comment)
 	}
-	const limitNumFiles = 20 // arbitrary
 	numFiles := len(a.Files) + fs.Num()
-	if numFiles > limitNumFiles {
-		return nil, fmt.Errorf("too many files in txtar archive (%v exceeds limit of %v)", numFiles, limitNumFiles)
+	if numFiles > lim.numFiles {
+		return nil, &LimitError{What: "number of files", Value: numFiles, Limit: lim.numFiles}
 	}
 	for _, f := range a.Files {
-		if len(f.Name) > 200 { // arbitrary limit
-			return nil, errors.New("file name too long")
-		}
-		if strings.IndexFunc(f.Name, isBogusFilenameRune) != -1 {
-			return nil, fmt.Errorf("invalid file name %q", f.Name)
-		}
-		if f.Name != path.Clean(f.Name) || path.IsAbs(f.Name) {
-			return nil, fmt.Errorf("invalid file name %q", f.Name)
-		}
-		parts := strings.Split(f.Name, "/")
-		if len(parts) > 10 { // arbitrary limit
-			return nil, fmt.Errorf("file name %q too deep", f.Name)
-		}
-		for _, part := range parts {
-			if part == "." || part == ".." {
-				return nil, fmt.Errorf("invalid file name %q", f.Name)
+		name, data := f.Name, f.Data
+		if strings.HasSuffix(name, base64Suffix) {
+			name = strings.TrimSuffix(name, base64Suffix)
+			var err error
+			if data, err = decodeBase64(data); err != nil {
+				return nil, &FileError{Name: name, Reason: "bad base64 content"}
 			}
 		}
-		if fs.Contains(f.Name) {
-			return nil, fmt.Errorf("duplicate file name %q", f.Name)
+		if err := checkFileName(name, lim); err != nil {
+			return nil, err
+		}
+		if fs.Contains(name) {
+			return nil, &FileError{Name: name, Reason: "duplicate file name"}
 		}
-		fs.AddFile(f.Name, f.Data)
+		fs.AddFile(name, data)
 	}
 	return fs, nil
 }
 
+// checkFileName reports an error if name is not acceptable as the name
+// of a file in a fileSet. See splitFiles for the rules.
+func checkFileName(name string, lim fileLimits) error {
+	if len(name) > lim.nameLen {
+		return &LimitError{What: "file name length", Value: len(name), Limit: lim.nameLen}
+	}
+	if strings.IndexFunc(name, isBogusFilenameRune) != -1 {
+		return &FileError{Name: name, Reason: "invalid file name"}
+	}
+	if name != path.Clean(name) || path.IsAbs(name) {
+		return &FileError{Name: name, Reason: "invalid file name"}
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) > lim.depth {
+		return &LimitError{What: "file name depth", Value: len(parts), Limit: lim.depth}
+	}
+	for _, part := range parts {
+		if part == "." || part == ".." {
+			return &FileError{Name: name, Reason: "invalid file name"}
+		}
+	}
+	return nil
+}
+
 // isBogusFilenameRune reports whether r should be rejected if it
 // appears in a txtar section's filename.
-func isBogusFilenameRune(r rune) bool { return r == '\\' || r < ' ' }
\ No newline at end of file
+func isBogusFilenameRune(r rune) bool { return r == '\\' || r < ' ' }
+
+// isText reports whether data is a text file that can be inlined in a
+// txtar document and read back as it is: one that ends in a newline, if
+// not empty, and has no line that would be taken for a file marker.
+func isText(data []byte) bool {
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) != -1 {
+		return false
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		return false
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if bytes.HasPrefix(line, []byte("-- ")) && bytes.HasSuffix(line, []byte(" --")) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeBase64 encodes data as base64 in lines of 76 characters.
+func encodeBase64(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(enc) > 76 {
+		buf.WriteString(enc[:76])
+		buf.WriteByte('\n')
+		enc = enc[76:]
+	}
+	buf.WriteString(enc)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// decodeBase64 decodes the output of encodeBase64, ignoring whitespace.
+func decodeBase64(data []byte) ([]byte, error) {
+	clean := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, data)
+	return base64.StdEncoding.DecodeString(string(clean))
+}
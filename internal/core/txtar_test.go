@@ -0,0 +1,135 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testLimits = fileLimits{numFiles: 3, nameLen: 20, depth: 3, size: 1 << 10}
+
+func TestSplitFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		files []string // in order, with their content below
+		data  []string
+		err   interface{} // pointer to the expected error type, if any
+	}{
+		{
+			name:  "implicit prog.go",
+			src:   "package main\n",
+			files: []string{"prog.go"},
+			data:  []string{"package main\n"},
+		},
+		{
+			name:  "sections",
+			src:   "-- main.spx --\nrun\n-- go.mod --\nmodule x\n",
+			files: []string{"main.spx", "go.mod"},
+			data:  []string{"run\n", "module x\n"},
+		},
+		{
+			name:  "comment and sections",
+			src:   "package main\n-- a/b.go --\npackage b\n",
+			files: []string{"prog.go", "a/b.go"},
+			data:  []string{"package main\n", "package b\n"},
+		},
+		{
+			name:  "base64",
+			src:   "-- cat.png;base64 --\nAAEC\n",
+			files: []string{"cat.png"},
+			data:  []string{"\x00\x01\x02"},
+		},
+		{name: "bad base64", src: "-- cat.png;base64 --\n!!\n", err: new(*FileError)},
+		{name: "duplicate", src: "-- a.go --\n-- a.go --\n", err: new(*FileError)},
+		{name: "explicit and implicit prog.go", src: "x\n-- prog.go --\n", err: new(*FileError)},
+		{name: "absolute", src: "-- /a.go --\n", err: new(*FileError)},
+		{name: "dot dot", src: "-- ../a.go --\n", err: new(*FileError)},
+		{name: "not clean", src: "-- a//b.go --\n", err: new(*FileError)},
+		{name: "backslash", src: "-- a\\b.go --\n", err: new(*FileError)},
+		{name: "too many files", src: "-- a --\n-- b --\n-- c --\n-- d --\n", err: new(*LimitError)},
+		{name: "name too long", src: "-- " + strings.Repeat("a", 21) + " --\n", err: new(*LimitError)},
+		{name: "too deep", src: "-- a/b/c/d --\n", err: new(*LimitError)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := splitFiles([]byte(tt.src), testLimits)
+			if tt.err != nil {
+				if !errors.As(err, tt.err) {
+					t.Fatalf("err = %v, want %T", err, reflect.ValueOf(tt.err).Elem().Interface())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fs.files, tt.files) {
+				t.Fatalf("files = %q, want %q", fs.files, tt.files)
+			}
+			for i, f := range tt.files {
+				if got := string(fs.Data(f)); got != tt.data[i] {
+					t.Errorf("%s = %q, want %q", f, got, tt.data[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	src := "package main\n-- main.spx --\nrun\n-- cat.png;base64 --\nAAEC\n"
+	fs, err := splitFiles([]byte(src), testLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fs.Format()); got != src {
+		t.Errorf("Format() = %q, want %q", got, src)
+	}
+
+	// Text that txtar can't hold as it is goes base64.
+	for _, data := range []string{
+		"a\n-- b.spx --\nc\n",
+		"-- b.spx --\r\n",
+		"no final newline",
+		"",
+	} {
+		fs := new(fileSet)
+		fs.AddFile("a.spx", []byte(data))
+		fs.AddFile("z.spx", []byte("z\n"))
+		back, err := splitFiles(fs.Format(), testLimits)
+		if err != nil {
+			t.Errorf("%q: %v", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(back.files, fs.files) || string(back.Data("a.spx")) != data {
+			t.Errorf("%q came back as %q: %q", data, back.files, back.Data("a.spx"))
+		}
+	}
+}
+
+func TestUnzipFilesSize(t *testing.T) {
+	zipOf := func(files ...string) []byte {
+		fs := new(fileSet)
+		for i := 0; i < len(files); i += 2 {
+			fs.AddFile(files[i], []byte(files[i+1]))
+		}
+		data, err := zipFiles(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	half := strings.Repeat("x", 512)
+	if _, err := unzipFiles(zipOf("a", half, "b", half), testLimits); err != nil {
+		t.Errorf("files of exactly the limit: %v", err)
+	}
+	for _, data := range [][]byte{
+		zipOf("a", strings.Repeat("x", 1<<20)),
+		zipOf("a", half, "b", half, "c", "x"),
+	} {
+		var limitErr *LimitError
+		if _, err := unzipFiles(data, testLimits); !errors.As(err, &limitErr) || limitErr.What != "unpacked size" {
+			t.Errorf("err = %v, want an unpacked size *LimitError", err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -9,18 +10,23 @@ import (
 	"strconv"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/scrypt"
 )
 
 // Upload file to cloud
 func UploadFile(ctx context.Context, p *Project, blobKey string, file multipart.File, header *multipart.FileHeader) (string, error) {
-	originalFilename := header.Filename
+	return UploadReader(ctx, p, blobKey, header.Filename, file)
+}
 
-	// 提取文件扩展名
-	ext := filepath.Ext(originalFilename)
+// UploadReader uploads the content of r under blobKey. originalFilename
+// only contributes the extension of the stored object.
+func UploadReader(ctx context.Context, p *Project, blobKey string, originalFilename string, r io.Reader) (key string, err error) {
+	blobKey = newBlobKey(blobKey, originalFilename)
 
-	//文件名加密
-	blobKey = blobKey + Encrypt(time.Now().String(), originalFilename) + ext
+	ctx, span := tracer.Start(ctx, "blob.Upload", trace.WithAttributes(attribute.String("blob.key", blobKey)))
+	defer func() { endSpan(span, err) }()
 
 	// 创建 blob writer
 	w, err := p.bucket.NewWriter(ctx, blobKey, nil)
@@ -30,7 +36,9 @@ func UploadFile(ctx context.Context, p *Project, blobKey string, file multipart.
 	defer w.Close()
 
 	// 将文件内容复制到 blob writer
-	_, err = io.Copy(w, file)
+	n, err := io.Copy(w, r)
+	p.metrics.uploadBytes.Add(float64(n))
+	span.SetAttributes(attribute.Int64("blob.size", n))
 	if err != nil {
 		return "", err
 	}
@@ -39,39 +47,58 @@ func UploadFile(ctx context.Context, p *Project, blobKey string, file multipart.
 	return blobKey, w.Close()
 }
 
+// newBlobKey returns a fresh key under prefix for a file named
+// originalFilename, keeping its extension.
+func newBlobKey(prefix, originalFilename string) string {
+	// 提取文件扩展名
+	ext := filepath.Ext(originalFilename)
+
+	//文件名加密
+	return prefix + Encrypt(time.Now().String(), originalFilename) + ext
+}
+
 func Encrypt(salt, password string) string {
 	dk, _ := scrypt.Key([]byte(password), []byte(salt), 32768, 8, 1, 32)
 	return fmt.Sprintf("%x", string(dk))
 }
 
-func AddProject(p *Project, c *CodeFile) (string, error) {
-	sqlStr := "insert into project (name,author_id , address, c_time,u_time) values (?, ?, ?, ?, ?)"
-	res, err := p.db.Exec(sqlStr, c.Name, c.AuthorId, c.Address, time.Now(), time.Now())
+func AddProject(ctx context.Context, p *Project, c *CodeFile) (string, error) {
+	if c.Visibility == "" {
+		c.Visibility = VisibilityUnlisted
+	}
+	forkedFrom := sql.NullString{String: c.ForkedFrom, Valid: c.ForkedFrom != ""}
+	sqlStr := "insert into project (name,author_id , address, size, forked_from, visibility, thumbnail, c_time,u_time) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	res, err := p.db.ExecContext(ctx, sqlStr, c.Name, c.AuthorId, c.Address, c.Size, forkedFrom, c.Visibility, p.thumbnailKey(c.Thumbnail), time.Now(), time.Now())
 	if err != nil {
-		println(err.Error())
 		return "", err
 	}
 	idInt, err := res.LastInsertId()
 	return strconv.Itoa(int(idInt)), err
 }
 
-func GetProjectAddress(id string, p *Project) string {
+func GetProjectAddress(ctx context.Context, id string, p *Project) string {
 	var address string
 	query := "SELECT address FROM project WHERE id = ?"
-	err := p.db.QueryRow(query, id).Scan(&address)
+	err := p.db.QueryRowContext(ctx, query, id).Scan(&address)
 	if err != nil {
 		return ""
 	}
 	return address
 }
 
-func UpdateProject(p *Project, c *CodeFile) error {
-	stmt, err := p.db.Prepare("UPDATE project SET name = ?, address = ? WHERE id = ?")
+func UpdateProject(ctx context.Context, p *Project, c *CodeFile) error {
+	if c.Visibility == "" {
+		err := p.db.QueryRowContext(ctx, "SELECT visibility FROM project WHERE id = ?", c.ID).Scan(&c.Visibility)
+		if err != nil {
+			return err
+		}
+	}
+	stmt, err := p.db.PrepareContext(ctx, "UPDATE project SET name = ?, address = ?, size = ?, visibility = ?, thumbnail = ?, u_time = ? WHERE id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(c.Name, c.Address, c.ID)
+	_, err = stmt.ExecContext(ctx, c.Name, c.Address, c.Size, c.Visibility, p.thumbnailKey(c.Thumbnail), time.Now(), c.ID)
 	return err
 }
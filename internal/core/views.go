@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// viewWindow is how long the views of a project or asset by the same
+// viewer count as one.
+const viewWindow = time.Hour
+
+// maxViewLog caps the number of views a viewLog remembers, so a flood of
+// made-up viewers can't exhaust memory.
+const maxViewLog = 100000
+
+// A viewLog remembers who viewed what during the last viewWindow. It is
+// kept in memory, so behind a load balancer a viewer may be counted once
+// per instance.
+type viewLog struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newViewLog() *viewLog {
+	return &viewLog{seen: make(map[string]time.Time)}
+}
+
+// first reports whether key wasn't seen during the viewWindow before now,
+// and records it. Once the log holds maxViewLog views, new keys aren't
+// recorded and first reports false for them until older ones expire.
+func (l *viewLog) first(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// A full log is swept early, but not on every view.
+	full := len(l.seen) >= maxViewLog
+	if since := now.Sub(l.lastSweep); since > viewWindow || full && since > time.Minute {
+		for k, t := range l.seen {
+			if now.Sub(t) > viewWindow {
+				delete(l.seen, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	if t, ok := l.seen[key]; ok && now.Sub(t) <= viewWindow || len(l.seen) >= maxViewLog {
+		return false
+	}
+	l.seen[key] = now
+	return true
+}
+
+// firstView reports whether the view of target by user uid at ip is the
+// first of the viewWindow. Views are told apart by client IP as well as
+// by user, as anyone can claim to be any user: one made up user id
+// after another from the same address still counts once.
+func (l *viewLog) firstView(target, uid, ip string, now time.Time) bool {
+	if !l.first(target+" ip:"+ip, now) {
+		return false
+	}
+	return uid == "" || l.first(target+" user:"+uid, now)
+}
+
+// RecordView counts a view of project c by user uid at ip, uid being
+// empty for anonymous users. Owners looking at their own projects don't
+// count, nor do the views of a user or an address that already viewed c
+// in the last hour.
+func (p *Project) RecordView(ctx context.Context, c *CodeFile, uid, ip string) {
+	if uid != "" && uid == c.AuthorId || !p.views.firstView("project "+c.ID, uid, ip, time.Now()) {
+		return
+	}
+	_, err := p.db.ExecContext(ctx, "UPDATE project SET view_count = view_count + 1 WHERE id = ?", c.ID)
+	if err != nil {
+		p.log.WarnContext(ctx, "recording view failed", "project", c.ID, "err", err)
+		return
+	}
+	c.ViewCount++
+}
+
+// RecordAssetView counts a view of asset a like RecordView does for
+// projects.
+func (p *Project) RecordAssetView(ctx context.Context, a *AssetResponse, uid, ip string) {
+	if uid != "" && uid == a.AuthorId || !p.views.firstView("asset "+a.ID, uid, ip, time.Now()) {
+		return
+	}
+	_, err := p.db.ExecContext(ctx, "UPDATE asset SET view_count = view_count + 1 WHERE id = ?", a.ID)
+	if err != nil {
+		p.log.WarnContext(ctx, "recording view failed", "asset", a.ID, "err", err)
+		return
+	}
+	a.ViewCount++
+}
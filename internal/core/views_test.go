@@ -0,0 +1,43 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestViewLogFirstView(t *testing.T) {
+	l := newViewLog()
+	now := time.Now()
+	for _, tt := range []struct {
+		uid, ip string
+		want    bool
+	}{
+		{"", "192.0.2.1", true},
+		{"", "192.0.2.1", false},
+		{"u1", "192.0.2.2", true},
+		{"u1", "192.0.2.3", false}, // the same user elsewhere
+		{"u2", "192.0.2.2", false}, // another user at the same address
+	} {
+		if got := l.firstView("project 1", tt.uid, tt.ip, now); got != tt.want {
+			t.Errorf("firstView(%q, %q) = %v, want %v", tt.uid, tt.ip, got, tt.want)
+		}
+	}
+	if !l.firstView("project 1", "", "192.0.2.1", now.Add(viewWindow+time.Second)) {
+		t.Error("view after viewWindow not counted")
+	}
+}
+
+func TestViewLogBounded(t *testing.T) {
+	l := newViewLog()
+	now := time.Now()
+	for i := 0; i < maxViewLog+10; i++ {
+		l.first(fmt.Sprint("project 1 ip:", i), now)
+	}
+	if n := len(l.seen); n > maxViewLog {
+		t.Errorf("%d views remembered, want at most %d", n, maxViewLog)
+	}
+	if !l.first("project 1 ip:x", now.Add(viewWindow+time.Second)) {
+		t.Error("full log didn't make room once its views expired")
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Visibility levels of a project. New projects are unlisted, as all
+// projects were before visibility levels existed.
+const (
+	VisibilityPrivate  = "private"  // only the owner sees it
+	VisibilityUnlisted = "unlisted" // anyone with its ID sees it
+	VisibilityPublic   = "public"   // anyone sees it, and it is listed
+)
+
+// SharePath is the path under which share tokens are resolved.
+const SharePath = "/s/"
+
+// A Share is a link to a project that works whatever its visibility.
+type Share struct {
+	Token string `json:"token"`
+	Path  string `json:"path"` // SharePath followed by Token
+}
+
+// UserID returns the ID of the user making request r, taken from the
+// X-User-Id header or the uid parameter.
+func UserID(r *http.Request) string {
+	if uid := r.Header.Get("X-User-Id"); uid != "" {
+		return uid
+	}
+	return r.FormValue("uid")
+}
+
+func checkVisibility(v string) error {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return nil
+	}
+	return fmt.Errorf("%w: visibility %q is not private, unlisted or public", ErrInvalidParam, v)
+}
+
+// visibleTo reports whether user uid may see c.
+func (c *CodeFile) visibleTo(uid string) bool {
+	return c.Visibility != VisibilityPrivate || uid != "" && uid == c.AuthorId
+}
+
+// owned returns project id if uid owns it, and ErrForbidden if uid
+// merely sees it.
+func (p *Project) owned(ctx context.Context, id, uid string) (*CodeFile, error) {
+	c, err := p.FileInfo(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	if uid == "" || uid != c.AuthorId {
+		return nil, ErrForbidden
+	}
+	return c, nil
+}
+
+// SetVisibility changes the visibility of project id, which must be
+// owned by uid.
+func (p *Project) SetVisibility(ctx context.Context, id, uid, visibility string) (*CodeFile, error) {
+	if err := checkVisibility(visibility); err != nil {
+		return nil, err
+	}
+	c, err := p.owned(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.db.ExecContext(ctx, "UPDATE project SET visibility = ? WHERE id = ?", visibility, id)
+	if err != nil {
+		return nil, err
+	}
+	c.Visibility = visibility
+	return c, nil
+}
+
+// ShareProject returns the share link of project id, which must be
+// owned by uid, making one up if the project isn't shared yet.
+func (p *Project) ShareProject(ctx context.Context, id, uid string) (*Share, error) {
+	c, err := p.owned(ctx, id, uid)
+	if err != nil {
+		return nil, err
+	}
+	if c.ShareToken == "" {
+		b := make([]byte, 9)
+		if _, err = rand.Read(b); err != nil {
+			return nil, err
+		}
+		c.ShareToken = base64.RawURLEncoding.EncodeToString(b)
+		_, err = p.db.ExecContext(ctx, "UPDATE project SET share_token = ? WHERE id = ?", c.ShareToken, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Share{Token: c.ShareToken, Path: SharePath + c.ShareToken}, nil
+}
+
+// UnshareProject revokes the share link of project id, which must be
+// owned by uid.
+func (p *Project) UnshareProject(ctx context.Context, id, uid string) error {
+	if _, err := p.owned(ctx, id, uid); err != nil {
+		return err
+	}
+	_, err := p.db.ExecContext(ctx, "UPDATE project SET share_token = NULL WHERE id = ?", id)
+	return err
+}
+
+// SharedProject returns the project shared under token, whatever its
+// visibility.
+func (p *Project) SharedProject(ctx context.Context, token string) (*CodeFile, error) {
+	if token == "" {
+		return nil, ErrNotExist
+	}
+	var id string
+	err := p.db.QueryRowContext(ctx, "SELECT id FROM project WHERE share_token = ?", token).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.fileInfo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.ShareToken = ""
+	return c, nil
+}
@@ -0,0 +1,144 @@
+-- The tables of the service in the SQLite dialect, as they are after
+-- applying the migrations in sql/.
+CREATE TABLE project
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    name           VARCHAR(255) NOT NULL,
+    author_id      VARCHAR(64)  NOT NULL,
+    address        VARCHAR(255) NOT NULL,
+    size           BIGINT       NOT NULL DEFAULT 0,
+    forked_from    INT          NULL,
+    visibility     VARCHAR(16)  NOT NULL DEFAULT 'unlisted',
+    share_token    VARCHAR(32)  NULL,
+    status         INT          NOT NULL DEFAULT 1,
+    view_count     BIGINT       NOT NULL DEFAULT 0,
+    like_count     BIGINT       NOT NULL DEFAULT 0,
+    trending_score DOUBLE       NOT NULL DEFAULT 0,
+    thumbnail      VARCHAR(255) NOT NULL DEFAULT '',
+    c_time         DATETIME     NOT NULL,
+    u_time         DATETIME     NOT NULL
+);
+CREATE INDEX idx_project_author_id ON project (author_id);
+CREATE INDEX idx_project_forked_from ON project (forked_from);
+CREATE UNIQUE INDEX idx_project_share_token ON project (share_token);
+CREATE INDEX idx_project_gallery_recent ON project (visibility, status, c_time);
+CREATE INDEX idx_project_gallery_trending ON project (visibility, status, trending_score);
+
+CREATE TABLE asset
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    name           VARCHAR(255) NOT NULL,
+    author_id      VARCHAR(64)  NOT NULL,
+    category       VARCHAR(64)  NOT NULL DEFAULT '',
+    is_public      INT          NOT NULL DEFAULT 0,
+    address        TEXT         NOT NULL,
+    asset_type     VARCHAR(64)  NOT NULL,
+    status         INT          NOT NULL DEFAULT 1,
+    size           BIGINT       NOT NULL DEFAULT 0,
+    view_count     BIGINT       NOT NULL DEFAULT 0,
+    favorite_count BIGINT       NOT NULL DEFAULT 0,
+    thumbnail      VARCHAR(255) NOT NULL DEFAULT '',
+    duration       DOUBLE       NOT NULL DEFAULT 0,
+    sample_rate    INT          NOT NULL DEFAULT 0,
+    channels       INT          NOT NULL DEFAULT 0,
+    c_time         DATETIME     NOT NULL,
+    u_time         DATETIME     NOT NULL
+);
+CREATE INDEX idx_asset_author_id ON asset (author_id);
+
+CREATE TABLE project_revision
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id INT          NOT NULL,
+    address    VARCHAR(255) NOT NULL,
+    size       BIGINT       NOT NULL DEFAULT 0,
+    c_time     DATETIME     NOT NULL
+);
+CREATE INDEX idx_project_revision_project_id ON project_revision (project_id);
+
+CREATE TABLE project_like
+(
+    project_id INT         NOT NULL,
+    user_id    VARCHAR(64) NOT NULL,
+    client_ip  VARCHAR(64) NOT NULL DEFAULT '',
+    c_time     DATETIME    NOT NULL,
+    PRIMARY KEY (project_id, user_id)
+);
+CREATE INDEX idx_project_like_client_ip ON project_like (project_id, client_ip);
+
+CREATE TABLE asset_favorite
+(
+    asset_id  INT         NOT NULL,
+    user_id   VARCHAR(64) NOT NULL,
+    client_ip VARCHAR(64) NOT NULL DEFAULT '',
+    c_time    DATETIME    NOT NULL,
+    PRIMARY KEY (asset_id, user_id)
+);
+CREATE INDEX idx_asset_favorite_client_ip ON asset_favorite (asset_id, client_ip);
+CREATE INDEX idx_asset_favorite_user ON asset_favorite (user_id, c_time);
+
+CREATE TABLE comment
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    project_id   INT         NOT NULL,
+    parent_id    INT         NULL,
+    author_id    VARCHAR(64) NOT NULL,
+    body         TEXT        NOT NULL,
+    status       INT         NOT NULL DEFAULT 1,
+    report_count BIGINT      NOT NULL DEFAULT 0,
+    c_time       DATETIME    NOT NULL,
+    u_time       DATETIME    NOT NULL
+);
+CREATE INDEX idx_comment_project_id ON comment (project_id);
+
+CREATE TABLE comment_report
+(
+    comment_id INT          NOT NULL,
+    user_id    VARCHAR(64)  NOT NULL,
+    client_ip  VARCHAR(64)  NOT NULL DEFAULT '',
+    reason     VARCHAR(255) NOT NULL DEFAULT '',
+    c_time     DATETIME     NOT NULL,
+    PRIMARY KEY (comment_id, user_id)
+);
+CREATE INDEX idx_comment_report_client_ip ON comment_report (comment_id, client_ip);
+
+CREATE TABLE class
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       VARCHAR(255) NOT NULL,
+    teacher_id VARCHAR(64)  NOT NULL,
+    join_code  VARCHAR(16)  NOT NULL,
+    c_time     DATETIME     NOT NULL
+);
+CREATE UNIQUE INDEX idx_class_join_code ON class (join_code);
+CREATE INDEX idx_class_teacher_id ON class (teacher_id);
+
+CREATE TABLE class_member
+(
+    class_id   INT         NOT NULL,
+    student_id VARCHAR(64) NOT NULL,
+    c_time     DATETIME    NOT NULL,
+    PRIMARY KEY (class_id, student_id)
+);
+CREATE INDEX idx_class_member_student_id ON class_member (student_id);
+
+CREATE TABLE assignment
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    class_id    INT          NOT NULL,
+    title       VARCHAR(255) NOT NULL,
+    description TEXT         NOT NULL,
+    starter_id  INT          NOT NULL,
+    due_time    DATETIME     NULL,
+    c_time      DATETIME     NOT NULL
+);
+CREATE INDEX idx_assignment_class_id ON assignment (class_id);
+
+CREATE TABLE submission
+(
+    assignment_id INT         NOT NULL,
+    student_id    VARCHAR(64) NOT NULL,
+    project_id    INT         NOT NULL,
+    c_time        DATETIME    NOT NULL,
+    PRIMARY KEY (assignment_id, student_id)
+);
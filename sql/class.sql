@@ -0,0 +1,39 @@
+-- Classes of a teacher, the students enrolled in them, and assignments
+-- whose submissions are forks of a starter project.
+CREATE TABLE IF NOT EXISTS class
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    teacher_id VARCHAR(64)  NOT NULL,
+    join_code  VARCHAR(16)  NOT NULL,
+    c_time     DATETIME     NOT NULL,
+    UNIQUE INDEX idx_class_join_code (join_code),
+    INDEX idx_class_teacher_id (teacher_id)
+);
+CREATE TABLE IF NOT EXISTS class_member
+(
+    class_id   INT         NOT NULL,
+    student_id VARCHAR(64) NOT NULL,
+    c_time     DATETIME    NOT NULL,
+    PRIMARY KEY (class_id, student_id),
+    INDEX idx_class_member_student_id (student_id)
+);
+CREATE TABLE IF NOT EXISTS assignment
+(
+    id          INT AUTO_INCREMENT PRIMARY KEY,
+    class_id    INT          NOT NULL,
+    title       VARCHAR(255) NOT NULL,
+    description TEXT         NOT NULL,
+    starter_id  INT          NOT NULL,
+    due_time    DATETIME     NULL,
+    c_time      DATETIME     NOT NULL,
+    INDEX idx_assignment_class_id (class_id)
+);
+CREATE TABLE IF NOT EXISTS submission
+(
+    assignment_id INT         NOT NULL,
+    student_id    VARCHAR(64) NOT NULL,
+    project_id    INT         NOT NULL,
+    c_time        DATETIME    NOT NULL,
+    PRIMARY KEY (assignment_id, student_id)
+);
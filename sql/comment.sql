@@ -0,0 +1,23 @@
+-- Comments on projects, threaded through parent_id. status 0 is deleted,
+-- 1 visible and 2 hidden after too many reports.
+CREATE TABLE IF NOT EXISTS comment
+(
+    id           INT AUTO_INCREMENT PRIMARY KEY,
+    project_id   INT          NOT NULL,
+    parent_id    INT          NULL,
+    author_id    VARCHAR(64)  NOT NULL,
+    body         TEXT         NOT NULL,
+    status       TINYINT      NOT NULL DEFAULT 1,
+    report_count BIGINT       NOT NULL DEFAULT 0,
+    c_time       DATETIME     NOT NULL,
+    u_time       DATETIME     NOT NULL,
+    INDEX idx_comment_project_id (project_id)
+);
+CREATE TABLE IF NOT EXISTS comment_report
+(
+    comment_id INT          NOT NULL,
+    user_id    VARCHAR(64)  NOT NULL,
+    reason     VARCHAR(255) NOT NULL DEFAULT '',
+    c_time     DATETIME     NOT NULL,
+    PRIMARY KEY (comment_id, user_id)
+);
@@ -0,0 +1,19 @@
+-- Likes of projects, favorites of assets, and the counters they keep up
+-- to date. A user likes a project or favorites an asset at most once.
+CREATE TABLE IF NOT EXISTS project_like
+(
+    project_id INT         NOT NULL,
+    user_id    VARCHAR(64) NOT NULL,
+    c_time     DATETIME    NOT NULL,
+    PRIMARY KEY (project_id, user_id)
+);
+CREATE TABLE IF NOT EXISTS asset_favorite
+(
+    asset_id INT         NOT NULL,
+    user_id  VARCHAR(64) NOT NULL,
+    c_time   DATETIME    NOT NULL,
+    PRIMARY KEY (asset_id, user_id),
+    INDEX idx_asset_favorite_user (user_id, c_time)
+);
+ALTER TABLE asset ADD COLUMN view_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE asset ADD COLUMN favorite_count BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+-- The client IP likes, favorites and comment reports were made from.
+-- Their counters count distinct IPs, so existing rows get one of their
+-- own each.
+ALTER TABLE project_like ADD COLUMN client_ip VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE project_like SET client_ip = CONCAT('user:', user_id);
+CREATE INDEX idx_project_like_client_ip ON project_like (project_id, client_ip);
+ALTER TABLE asset_favorite ADD COLUMN client_ip VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE asset_favorite SET client_ip = CONCAT('user:', user_id);
+CREATE INDEX idx_asset_favorite_client_ip ON asset_favorite (asset_id, client_ip);
+ALTER TABLE comment_report ADD COLUMN client_ip VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE comment_report SET client_ip = CONCAT('user:', user_id);
+CREATE INDEX idx_comment_report_client_ip ON comment_report (comment_id, client_ip);
@@ -0,0 +1,3 @@
+-- The project a fork was copied from, NULL for original projects.
+ALTER TABLE project ADD COLUMN forked_from INT NULL;
+CREATE INDEX idx_project_forked_from ON project (forked_from);
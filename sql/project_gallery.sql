@@ -0,0 +1,10 @@
+-- Counters and trending score of the public gallery. status follows the
+-- asset table: 0 is deleted. common.QueryByPage, which lists the gallery,
+-- filters on it, so the project table needs it even before projects can
+-- be deleted.
+ALTER TABLE project ADD COLUMN status TINYINT NOT NULL DEFAULT 1;
+ALTER TABLE project ADD COLUMN view_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE project ADD COLUMN like_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE project ADD COLUMN trending_score DOUBLE NOT NULL DEFAULT 0;
+CREATE INDEX idx_project_gallery_recent ON project (visibility, status, c_time);
+CREATE INDEX idx_project_gallery_trending ON project (visibility, status, trending_score);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS project_revision
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    project_id INT          NOT NULL,
+    address    VARCHAR(255) NOT NULL,
+    c_time     DATETIME     NOT NULL,
+    INDEX idx_project_revision_project_id (project_id)
+);
@@ -0,0 +1,7 @@
+-- Who may see a project: private (the owner), unlisted (anyone with its
+-- ID) or public (also listed). Projects from before were readable by
+-- anyone, so they become unlisted. share_token is set while the owner
+-- shares the project by link.
+ALTER TABLE project ADD COLUMN visibility VARCHAR(16) NOT NULL DEFAULT 'unlisted';
+ALTER TABLE project ADD COLUMN share_token VARCHAR(32) NULL;
+CREATE UNIQUE INDEX idx_project_share_token ON project (share_token);
@@ -0,0 +1,5 @@
+-- Duration in seconds, sample rate and channel count of sound assets,
+-- read from their headers when uploaded. 0 for other assets.
+ALTER TABLE asset ADD COLUMN duration DOUBLE NOT NULL DEFAULT 0;
+ALTER TABLE asset ADD COLUMN sample_rate INT NOT NULL DEFAULT 0;
+ALTER TABLE asset ADD COLUMN channels INT NOT NULL DEFAULT 0;
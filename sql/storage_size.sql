@@ -0,0 +1,8 @@
+-- Sizes of stored bundles and assets, summed up per author_id to
+-- enforce STORAGE_QUOTA_MB.
+ALTER TABLE project ADD COLUMN size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE asset ADD COLUMN size BIGINT NOT NULL DEFAULT 0;
+-- Revisions keep the bundles of previous versions, which count too.
+ALTER TABLE project_revision ADD COLUMN size BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX idx_project_author_id ON project (author_id);
+CREATE INDEX idx_asset_author_id ON asset (author_id);
@@ -0,0 +1,5 @@
+-- Keys of the preview images of projects and assets in the bucket,
+-- empty until one is rendered. '-' marks an asset without one, whose
+-- thumbnail isn't tried again.
+ALTER TABLE project ADD COLUMN thumbnail VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE asset ADD COLUMN thumbnail VARCHAR(255) NOT NULL DEFAULT '';